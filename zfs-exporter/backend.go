@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/ncabatoff/go-libzfs"
+)
+
+type (
+	// Backend discovers the pools to be collected.  libzfsBackend is the real
+	// implementation; fakeBackend, in the tests, serves canned pools so the
+	// collector can be tested without ZFS loaded in the kernel.
+	Backend interface {
+		// OpenPools returns a handle for every imported pool.  Each handle
+		// must be closed by the caller once it's no longer needed.
+		OpenPools() ([]PoolHandle, error)
+		// ImportablePools scans the devices in searchPaths, or the libzfs
+		// default if empty, for pools that could be imported.
+		ImportablePools(searchPaths []string) ([]zfs.ExportedPool, error)
+	}
+
+	// PoolHandle is the subset of a libzfs pool handle used by ZfsCollector.
+	PoolHandle interface {
+		Name() string
+		// GUID returns the pool's guid, which unlike its name is unique.
+		GUID() uint64
+		RefreshStats() error
+		VDevTree() (zfs.VDevTree, error)
+		State() (zfs.PoolState, error)
+		Status() (zfs.PoolStatus, error)
+		// GetProperty rereads a single pool property.
+		GetProperty(p zfs.Prop) (zfs.Property, error)
+		// ReloadProperties rereads all pool properties and features.
+		ReloadProperties() error
+		// Properties returns the pool properties as of the last reload,
+		// indexed by zfs.Prop.
+		Properties() []zfs.Property
+		// Features returns the state of each feature flag known to libzfs,
+		// e.g. disabled, enabled or active, as of the last reload.
+		Features() map[string]string
+		// OpenDatasets returns the pool's root dataset along with all its
+		// descendants, snapshots included.  It must be closed by the caller.
+		OpenDatasets() (DatasetHandle, error)
+		Close()
+	}
+
+	// DatasetHandle is the subset of a libzfs dataset handle used by the
+	// dataset sub-collectors.
+	DatasetHandle interface {
+		Type() zfs.DatasetType
+		// Properties returns the properties read when the dataset was
+		// opened.
+		Properties() map[zfs.Prop]zfs.Property
+		// PropertyByName reads a property zfs.Prop has no value for, e.g.
+		// encryption on newer ZFS versions.
+		PropertyByName(name string) (zfs.Property, error)
+		// UserProperty reads a user property, e.g. com.acme:owner.  It
+		// returns an error if the property isn't set.
+		UserProperty(name string) (zfs.Property, error)
+		// IsMounted reports whether the dataset is mounted, and where.
+		IsMounted() (bool, string)
+		Children() []DatasetHandle
+		// Close closes the dataset and its descendants.
+		Close()
+	}
+
+	libzfsBackend struct{}
+
+	libzfsPool struct {
+		pool zfs.Pool
+		// name and guid are read once at open so that Name and GUID
+		// don't race with a property reload still running for a hung
+		// pool.
+		name string
+		guid uint64
+	}
+
+	libzfsDataset struct {
+		dataset *zfs.Dataset
+	}
+)
+
+// OpenPools implements Backend.
+func (libzfsBackend) OpenPools() ([]PoolHandle, error) {
+	pools, err := zfs.PoolOpenAll()
+	if err != nil {
+		zfs.PoolCloseAll(pools)
+		return nil, err
+	}
+	handles := make([]PoolHandle, 0, len(pools))
+	for _, pool := range pools {
+		guid, _ := strconv.ParseUint(pool.Properties[zfs.PoolPropGUID].Value, 10, 64)
+		handles = append(handles, &libzfsPool{pool: pool, name: pool.Properties[zfs.PoolPropName].Value, guid: guid})
+	}
+	return handles, nil
+}
+
+// ImportablePools implements Backend.
+func (libzfsBackend) ImportablePools(searchPaths []string) ([]zfs.ExportedPool, error) {
+	return zfs.PoolImportSearch(searchPaths)
+}
+
+func (p *libzfsPool) Name() string {
+	return p.name
+}
+
+func (p *libzfsPool) GUID() uint64 {
+	return p.guid
+}
+
+func (p *libzfsPool) RefreshStats() error {
+	return p.pool.RefreshStats()
+}
+
+func (p *libzfsPool) VDevTree() (zfs.VDevTree, error) {
+	return p.pool.VDevTree()
+}
+
+func (p *libzfsPool) State() (zfs.PoolState, error) {
+	return p.pool.State()
+}
+
+func (p *libzfsPool) Status() (zfs.PoolStatus, error) {
+	return p.pool.Status()
+}
+
+func (p *libzfsPool) GetProperty(prop zfs.Prop) (zfs.Property, error) {
+	return p.pool.GetProperty(prop)
+}
+
+func (p *libzfsPool) ReloadProperties() error {
+	return p.pool.ReloadProperties()
+}
+
+func (p *libzfsPool) Properties() []zfs.Property {
+	return p.pool.Properties
+}
+
+func (p *libzfsPool) Features() map[string]string {
+	return p.pool.Features
+}
+
+func (p *libzfsPool) OpenDatasets() (DatasetHandle, error) {
+	d, err := zfs.DatasetOpen(p.name)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return &libzfsDataset{dataset: &d}, nil
+}
+
+func (p *libzfsPool) Close() {
+	p.pool.Close()
+}
+
+func (d *libzfsDataset) Type() zfs.DatasetType {
+	return d.dataset.Type
+}
+
+func (d *libzfsDataset) Properties() map[zfs.Prop]zfs.Property {
+	return d.dataset.Properties
+}
+
+func (d *libzfsDataset) PropertyByName(name string) (zfs.Property, error) {
+	return d.dataset.GetPropertyByName(name)
+}
+
+func (d *libzfsDataset) UserProperty(name string) (zfs.Property, error) {
+	return d.dataset.GetUserProperty(name)
+}
+
+func (d *libzfsDataset) IsMounted() (bool, string) {
+	return d.dataset.IsMounted()
+}
+
+func (d *libzfsDataset) Children() []DatasetHandle {
+	children := make([]DatasetHandle, 0, len(d.dataset.Children))
+	for i := range d.dataset.Children {
+		children = append(children, &libzfsDataset{dataset: &d.dataset.Children[i]})
+	}
+	return children
+}
+
+func (d *libzfsDataset) Close() {
+	d.dataset.Close()
+}
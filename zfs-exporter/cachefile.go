@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ncabatoff/zfs-exporter/zfs-exporter/nvlist"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// cacheFilePath is the cache file read by the cachefile collector.  It's
+	// set from -zfs.cachefile before the collectors are created.
+	cacheFilePath = "/etc/zfs/zpool.cache"
+
+	expectedDesc = prometheus.NewDesc(
+		"zfs_zpool_expected",
+		"always 1; one series per pool in the cache file, i.e. expected to be imported at boot. imported is no if the pool with that guid isn't imported, even if another by the same name is.",
+		[]string{"poolname", "guid", "imported"},
+		nil)
+)
+
+// cachefileCollector reports the pools listed in the pool cache file, so that
+// one which failed to import is noticed rather than just having its metrics
+// disappear.
+type cachefileCollector struct {
+	path string
+}
+
+func init() {
+	registerHostCollector("cachefile", true, func() hostCollector {
+		return cachefileCollector{path: cacheFilePath}
+	})
+}
+
+// Describe implements hostCollector.
+func (cachefileCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- expectedDesc
+}
+
+// Update implements hostCollector.
+func (c cachefileCollector) Update(hs *hostScrape, ch chan<- prometheus.Metric) error {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		// No cache file just means no pools are expected.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pools, err := nvlist.Unpack(data)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %v", c.path, err)
+	}
+
+	imported := make(map[uint64]bool, len(hs.poolGUIDs))
+	for _, guid := range hs.poolGUIDs {
+		imported[guid] = true
+	}
+
+	// The cache file maps each pool's name to its config.
+	for _, p := range pools.Pairs {
+		config, ok := p.Value.(*nvlist.List)
+		if !ok {
+			continue
+		}
+		poolName, ok := config.String("name")
+		if !ok {
+			poolName = p.Name
+		}
+		guid, _ := config.Uint64("pool_guid")
+		isImported := "no"
+		if imported[guid] {
+			isImported = "yes"
+		}
+		ch <- prometheus.MustNewConstMetric(expectedDesc,
+			prometheus.GaugeValue,
+			1,
+			poolName, fmt.Sprintf("%d", guid), isImported)
+	}
+	return nil
+}
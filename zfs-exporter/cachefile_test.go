@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ncabatoff/zfs-exporter/zfs-exporter/nvlist"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestCachefileCollector checks the series reported for the pools in a cache
+// file, and that a missing cache file is fine but a corrupt one isn't.
+func TestCachefileCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	corrupt := filepath.Join(dir, "corrupt.cache")
+	if err := ioutil.WriteFile(corrupt, []byte{1, 1, 0, 0, 0, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := filepath.Join(dir, "zpool.cache")
+	writeCachefile(t, cache, []cachedPool{{"backup", 18446744073709551557}, {"tank", 1234567890123456789}})
+
+	for _, tc := range []struct {
+		name      string
+		path      string
+		poolGUIDs []uint64
+		// want maps the poolname label of each series to its guid and
+		// imported labels.
+		want    map[string][2]string
+		wantErr bool
+	}{
+		{
+			name: "missing",
+			path: filepath.Join(dir, "missing.cache"),
+			want: map[string][2]string{},
+		},
+		{
+			name:    "corrupt",
+			path:    corrupt,
+			want:    map[string][2]string{},
+			wantErr: true,
+		},
+		{
+			name:      "imported",
+			path:      cache,
+			poolGUIDs: []uint64{18446744073709551557, 1234567890123456789},
+			want: map[string][2]string{
+				"tank":   {"1234567890123456789", "yes"},
+				"backup": {"18446744073709551557", "yes"},
+			},
+		},
+		{
+			name:      "not imported",
+			path:      cache,
+			poolGUIDs: []uint64{1234567890123456789, 7},
+			want: map[string][2]string{
+				"tank":   {"1234567890123456789", "yes"},
+				"backup": {"18446744073709551557", "no"},
+			},
+		},
+		{
+			// Another pool called backup doesn't count.
+			name:      "same name, other guid",
+			path:      cache,
+			poolGUIDs: []uint64{1234567890123456789, 18446744073709551556},
+			want: map[string][2]string{
+				"tank":   {"1234567890123456789", "yes"},
+				"backup": {"18446744073709551557", "no"},
+			},
+		},
+	} {
+		ch := make(chan prometheus.Metric)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- cachefileCollector{path: tc.path}.Update(&hostScrape{poolGUIDs: tc.poolGUIDs}, ch)
+			close(ch)
+		}()
+		got := map[string][2]string{}
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				t.Fatal(err)
+			}
+			labels := map[string]string{}
+			for _, lp := range pb.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			got[labels["poolname"]] = [2]string{labels["guid"], labels["imported"]}
+		}
+		if err := <-errc; (err != nil) != tc.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tc.name, err, tc.wantErr)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// cachedPool is a pool listed in a cache file written by writeCachefile.
+type cachedPool struct {
+	name string
+	guid uint64
+}
+
+// writeCachefile writes a cache file listing pools to path, each with just
+// its name and guid.
+func writeCachefile(t *testing.T, path string, pools []cachedPool) {
+	l := &nvlist.List{Flags: 1}
+	for _, p := range pools {
+		l.Pairs = append(l.Pairs, nvlist.Pair{Name: p.name, Type: nvlist.TypeNvlist, Value: &nvlist.List{Flags: 1, Pairs: []nvlist.Pair{
+			{Name: "name", Type: nvlist.TypeString, Value: p.name},
+			{Name: "pool_guid", Type: nvlist.TypeUint64, Value: p.guid},
+		}}})
+	}
+	data, err := nvlist.Pack(l, nvlist.XDR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	collectorDurationDesc = prometheus.NewDesc(
+		"zfs_exporter_collector_duration_seconds",
+		"time spent in the collector during the last refresh, summed over pools for per-pool collectors.",
+		[]string{"collector"},
+		nil)
+
+	collectorSuccessDesc = prometheus.NewDesc(
+		"zfs_exporter_collector_success",
+		"1 if the collector succeeded, for every pool in the case of per-pool collectors, during the last refresh; a pool that timed out counts as a failure of every per-pool collector.",
+		[]string{"collector"},
+		nil)
+
+	// collectorDefs holds every sub-collector registered by
+	// registerCollector or registerHostCollector, keyed by name.
+	collectorDefs = make(map[string]*collectorDef)
+)
+
+type (
+	// subCollector produces one named group of per-pool metrics.  Each can be
+	// turned on or off with -collector.<name> / -no-collector.<name>.
+	subCollector interface {
+		// Describe sends the descriptors of every metric Update may send.
+		Describe(ch chan<- *prometheus.Desc)
+		// Update sends the metrics for the pool being scraped to ch.
+		Update(ps *poolScrape, ch chan<- prometheus.Metric) error
+	}
+
+	// poolForgetter is implemented by sub-collectors that remember things
+	// about pools from one refresh to the next.  forgetPool is called when
+	// the pool goes away, i.e. is no longer found by discovery.
+	poolForgetter interface {
+		forgetPool(poolName string)
+	}
+
+	// hostCollector produces one named group of host-wide metrics, which
+	// don't belong to any imported pool.  It's turned on or off by flags
+	// the same way as a subCollector.
+	hostCollector interface {
+		// Describe sends the descriptors of every metric Update may send.
+		Describe(ch chan<- *prometheus.Desc)
+		// Update sends the host's metrics to ch.
+		Update(hs *hostScrape, ch chan<- prometheus.Metric) error
+	}
+
+	// hostScrape is handed to each host collector in turn during a refresh.
+	hostScrape struct {
+		backend Backend
+		// poolGUIDs are the guids of the pools imported as of the last
+		// discovery.
+		poolGUIDs []uint64
+	}
+
+	// collectorDef has exactly one of factory and hostFactory set.
+	collectorDef struct {
+		factory     func() subCollector
+		hostFactory func() hostCollector
+		enabled     bool
+	}
+
+	// collectorFlag is the flag.Value behind -collector.<name> (negate false)
+	// and -no-collector.<name> (negate true).
+	collectorFlag struct {
+		def    *collectorDef
+		negate bool
+	}
+
+	// poolScrape is handed to each sub-collector in turn while collecting a
+	// pool.  It caches the pool's vdev tree, properties and datasets so that
+	// they're fetched at most once per pool per refresh, however many
+	// sub-collectors need them.
+	poolScrape struct {
+		pool     PoolHandle
+		poolName string
+
+		vdevsRead bool
+		vdevs     zfs.VDevTree
+		vdevsErr  error
+
+		propsRead bool
+		propsErr  error
+
+		datasetsRead bool
+		datasets     DatasetHandle
+		datasetsErr  error
+	}
+
+	// collectorStats records how each sub-collector fared for one pool, or
+	// summed over all pools.
+	collectorStats map[string]collectorStat
+
+	collectorStat struct {
+		duration time.Duration
+		failed   bool
+	}
+)
+
+// registerCollector makes a sub-collector available under name.  It's meant
+// to be called from init functions.
+func registerCollector(name string, enabledByDefault bool, factory func() subCollector) {
+	if _, ok := collectorDefs[name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", name))
+	}
+	collectorDefs[name] = &collectorDef{factory: factory, enabled: enabledByDefault}
+}
+
+// registerHostCollector is registerCollector for host-wide collectors.
+func registerHostCollector(name string, enabledByDefault bool, factory func() hostCollector) {
+	if _, ok := collectorDefs[name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", name))
+	}
+	collectorDefs[name] = &collectorDef{hostFactory: factory, enabled: enabledByDefault}
+}
+
+// registerCollectorFlags adds -collector.<name> and -no-collector.<name> to
+// fs for each registered sub-collector.
+func registerCollectorFlags(fs *flag.FlagSet) {
+	for name, def := range collectorDefs {
+		state := "disabled"
+		if def.enabled {
+			state = "enabled"
+		}
+		fs.Var(&collectorFlag{def: def}, "collector."+name,
+			fmt.Sprintf("Enable the %s collector (%s by default).", name, state))
+		fs.Var(&collectorFlag{def: def, negate: true}, "no-collector."+name,
+			fmt.Sprintf("Disable the %s collector.", name))
+	}
+}
+
+// enabledCollectors instantiates every sub-collector enabled by flags.
+func enabledCollectors() map[string]subCollector {
+	collectors := make(map[string]subCollector)
+	for name, def := range collectorDefs {
+		if def.enabled && def.factory != nil {
+			collectors[name] = def.factory()
+		}
+	}
+	return collectors
+}
+
+// enabledHostCollectors instantiates every host collector enabled by flags.
+func enabledHostCollectors() map[string]hostCollector {
+	collectors := make(map[string]hostCollector)
+	for name, def := range collectorDefs {
+		if def.enabled && def.hostFactory != nil {
+			collectors[name] = def.hostFactory()
+		}
+	}
+	return collectors
+}
+
+// collectorNames returns the keys of collectors in a stable order.
+func collectorNames(collectors map[string]subCollector) []string {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *collectorFlag) String() string {
+	if f.def == nil {
+		return ""
+	}
+	return strconv.FormatBool(f.def.enabled != f.negate)
+}
+
+func (f *collectorFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f.def.enabled = v != f.negate
+	return nil
+}
+
+func (f *collectorFlag) IsBoolFlag() bool {
+	return true
+}
+
+// VDevTree returns the pool's vdev tree, reading it on first use.
+func (ps *poolScrape) VDevTree() (zfs.VDevTree, error) {
+	if !ps.vdevsRead {
+		ps.vdevs, ps.vdevsErr = ps.pool.VDevTree()
+		ps.vdevsRead = true
+	}
+	return ps.vdevs, ps.vdevsErr
+}
+
+// ReloadProperties rereads the pool's properties and features on first use.
+func (ps *poolScrape) ReloadProperties() error {
+	if !ps.propsRead {
+		ps.propsErr = ps.pool.ReloadProperties()
+		ps.propsRead = true
+	}
+	return ps.propsErr
+}
+
+// Datasets returns the pool's root dataset, opening it and its descendants on
+// first use.
+func (ps *poolScrape) Datasets() (DatasetHandle, error) {
+	if !ps.datasetsRead {
+		ps.datasets, ps.datasetsErr = ps.pool.OpenDatasets()
+		ps.datasetsRead = true
+	}
+	return ps.datasets, ps.datasetsErr
+}
+
+// Close releases whatever the scrape opened.
+func (ps *poolScrape) Close() {
+	if ps.datasets != nil {
+		ps.datasets.Close()
+		ps.datasets = nil
+	}
+}
+
+// add folds other into s.
+func (s collectorStats) add(other collectorStats) {
+	for name, stat := range other {
+		sum := s[name]
+		sum.duration += stat.duration
+		sum.failed = sum.failed || stat.failed
+		s[name] = sum
+	}
+}
+
+func (s collectorStats) collect(ch chan<- prometheus.Metric) {
+	for name, stat := range s {
+		ch <- prometheus.MustNewConstMetric(collectorDurationDesc,
+			prometheus.GaugeValue,
+			stat.duration.Seconds(),
+			name)
+
+		success := 1.0
+		if stat.failed {
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(collectorSuccessDesc,
+			prometheus.GaugeValue,
+			success,
+			name)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// datasetLimit is a quota or reservation along with the property it limits.
+type datasetLimit struct {
+	prop, usage zfs.Prop
+	desc        *prometheus.Desc
+	ratioDesc   *prometheus.Desc
+}
+
+// numericDatasetProp maps a numeric dataset property to the gauge it's
+// exported as.
+type numericDatasetProp struct {
+	prop zfs.Prop
+	desc *prometheus.Desc
+}
+
+var (
+	// datasetUserProperties are the user properties exported for each
+	// dataset.  It's set from -zfs.user-properties before the collectors are
+	// created.
+	datasetUserProperties []string
+
+	numericDatasetProps = []numericDatasetProp{
+		newNumericDatasetProp(zfs.DatasetPropUsed, "used_bytes", "space consumed by the dataset and all its descendants."),
+		newNumericDatasetProp(zfs.DatasetPropAvailable, "available_bytes", "space available to the dataset and all its children."),
+		newNumericDatasetProp(zfs.DatasetPropReferenced, "referenced_bytes", "space referenced by the dataset, possibly shared with other datasets."),
+		newNumericDatasetProp(zfs.DatasetPropLogicalused, "logicalused_bytes", "space consumed by the dataset and its descendants before compression."),
+		newNumericDatasetProp(zfs.DatasetPropLogicalreferenced, "logicalreferenced_bytes", "space referenced by the dataset before compression."),
+		newNumericDatasetProp(zfs.DatasetPropUsedsnap, "usedbysnapshots_bytes", "space that would be freed if all of the dataset's snapshots were destroyed."),
+		newNumericDatasetProp(zfs.DatasetPropUsedchild, "usedbychildren_bytes", "space that would be freed if all of the dataset's children were destroyed."),
+		newNumericDatasetProp(zfs.DatasetPropUsedds, "usedbydataset_bytes", "space that would be freed if the dataset itself were destroyed."),
+		newNumericDatasetProp(zfs.DatasetPropUsedrefreserv, "usedbyrefreservation_bytes", "space that would be freed if the dataset's refreservation were removed."),
+		newNumericDatasetProp(zfs.DatasetPropWritten, "written_bytes", "space referenced by the dataset that was written since its latest snapshot."),
+		newNumericDatasetProp(zfs.DatasetPropCompressratio, "compressratio", "compression ratio achieved for the space used by the dataset."),
+	}
+
+	// datasetLimits are only exported for datasets where they're set.
+	datasetLimits = []datasetLimit{
+		newDatasetLimit(zfs.DatasetPropQuota, zfs.DatasetPropUsed, "quota",
+			"limit on the space the dataset and its descendants can use.",
+			"fraction of the quota used by the dataset and its descendants."),
+		newDatasetLimit(zfs.DatasetPropRefquota, zfs.DatasetPropReferenced, "refquota",
+			"limit on the space the dataset itself can reference.",
+			"fraction of the refquota referenced by the dataset."),
+		newDatasetLimit(zfs.DatasetPropReservation, zfs.DatasetPropUsed, "reservation",
+			"space guaranteed to the dataset and its descendants.",
+			"fraction of the reservation used by the dataset and its descendants."),
+		newDatasetLimit(zfs.DatasetPropRefreservation, zfs.DatasetPropReferenced, "refreservation",
+			"space guaranteed to the dataset itself, excluding descendants.",
+			"fraction of the refreservation referenced by the dataset."),
+	}
+
+	datasetinfoDesc = prometheus.NewDesc(
+		"zfs_dataset_info",
+		"always 1; labels give the dataset's configuration. blocksize is the recordsize of a filesystem or the volblocksize of a volume; labels that don't apply to the dataset or its ZFS version are empty.",
+		[]string{"poolname", "dataset", "type", "compression", "blocksize", "sync", "dedup", "atime",
+			"encryption", "keystatus", "canmount", "mountpoint", "mounted"},
+		nil)
+
+	datasetuserpropDesc = prometheus.NewDesc(
+		"zfs_dataset_user_property_info",
+		"always 1; one series per user property named in -zfs.user-properties that's set on or inherited by the dataset.",
+		[]string{"poolname", "dataset", "property", "value"},
+		nil)
+
+	datasetuntilquotaDesc = prometheus.NewDesc(
+		"zfs_dataset_bytes_until_quota",
+		"space the dataset can still consume before hitting the tighter of its quota and refquota, negative if over; only exported if either is set.",
+		[]string{"poolname", "dataset", "type"},
+		nil)
+)
+
+func newDatasetLimit(prop, usage zfs.Prop, name, help, ratioHelp string) datasetLimit {
+	return datasetLimit{
+		prop:  prop,
+		usage: usage,
+		desc: prometheus.NewDesc(
+			"zfs_dataset_"+name+"_bytes",
+			help,
+			[]string{"poolname", "dataset", "type"},
+			nil),
+		ratioDesc: prometheus.NewDesc(
+			"zfs_dataset_"+name+"_utilization_ratio",
+			ratioHelp,
+			[]string{"poolname", "dataset", "type"},
+			nil),
+	}
+}
+
+func newNumericDatasetProp(prop zfs.Prop, name, help string) numericDatasetProp {
+	return numericDatasetProp{
+		prop: prop,
+		desc: prometheus.NewDesc(
+			"zfs_dataset_"+name,
+			help,
+			[]string{"poolname", "dataset", "type"},
+			nil),
+	}
+}
+
+// datasetCollector reports space usage and configuration of every
+// filesystem and volume.  It's off by default, as opening every dataset on
+// each refresh can take longer than -zfs.pool-timeout on large hosts.
+type datasetCollector struct {
+	userProps []string
+}
+
+func init() {
+	registerCollector("dataset", false, func() subCollector {
+		return datasetCollector{userProps: datasetUserProperties}
+	})
+}
+
+// Describe implements subCollector.
+func (datasetCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, np := range numericDatasetProps {
+		ch <- np.desc
+	}
+	for _, dl := range datasetLimits {
+		ch <- dl.desc
+		ch <- dl.ratioDesc
+	}
+	ch <- datasetuntilquotaDesc
+	ch <- datasetinfoDesc
+	ch <- datasetuserpropDesc
+}
+
+// Update implements subCollector.
+func (c datasetCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	root, err := ps.Datasets()
+	if err != nil {
+		return fmt.Errorf("unable to open datasets: %v", err)
+	}
+
+	var firstErr error
+	visitDatasets(root, func(d DatasetHandle) {
+		if d.Type() != zfs.DatasetTypeFilesystem && d.Type() != zfs.DatasetTypeVolume {
+			return
+		}
+		dtype := datasetTypeName(d.Type())
+		props := d.Properties()
+		name := props[zfs.DatasetPropName].Value
+		for _, np := range numericDatasetProps {
+			prop, ok := props[np.prop]
+			if !ok {
+				continue
+			}
+			v, ok, err := parsePropNumber(prop.Value)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("unable to parse property %s of %s: %v", zfs.DatasetPropertyToName(np.prop), name, err)
+				}
+				continue
+			}
+			if ok {
+				ch <- prometheus.MustNewConstMetric(np.desc, prometheus.GaugeValue, v, ps.poolName, name, dtype)
+			}
+		}
+		if err := collectDatasetLimits(ch, props, ps.poolName, name, dtype); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("unable to read limits of %s: %v", name, err)
+		}
+		collectDatasetInfo(ch, d, ps.poolName, name, dtype)
+		for _, userProp := range c.userProps {
+			if prop, err := d.UserProperty(userProp); err == nil {
+				ch <- prometheus.MustNewConstMetric(datasetuserpropDesc, prometheus.GaugeValue, 1,
+					ps.poolName, name, userProp, prop.Value)
+			}
+		}
+	})
+	return firstErr
+}
+
+// collectDatasetInfo sends zfs_dataset_info for d.
+func collectDatasetInfo(ch chan<- prometheus.Metric, d DatasetHandle, poolName, name, dtype string) {
+	props := d.Properties()
+	blocksize, mounted := props[zfs.DatasetPropRecordsize].Value, ""
+	if d.Type() == zfs.DatasetTypeVolume {
+		blocksize = props[zfs.DatasetPropVolblocksize].Value
+	} else if ok, _ := d.IsMounted(); ok {
+		mounted = "yes"
+	} else {
+		mounted = "no"
+	}
+	// Encryption arrived after the ZFS version zfs.Prop enumerates, so
+	// these are looked up by name and left empty where unsupported.
+	encryption, _ := d.PropertyByName("encryption")
+	keystatus, _ := d.PropertyByName("keystatus")
+
+	ch <- prometheus.MustNewConstMetric(datasetinfoDesc, prometheus.GaugeValue, 1,
+		poolName, name, dtype,
+		props[zfs.DatasetPropCompression].Value,
+		blocksize,
+		props[zfs.DatasetPropSync].Value,
+		props[zfs.DatasetPropDedup].Value,
+		props[zfs.DatasetPropAtime].Value,
+		encryption.Value,
+		keystatus.Value,
+		props[zfs.DatasetPropCanmount].Value,
+		props[zfs.DatasetPropMountpoint].Value,
+		mounted)
+}
+
+// collectDatasetLimits sends the quotas and reservations set on a dataset
+// with properties props, how much of each is used, and how far the dataset
+// is from its quotas.  Unset limits read as 0 and are skipped.
+func collectDatasetLimits(ch chan<- prometheus.Metric, props map[zfs.Prop]zfs.Property, labelValues ...string) error {
+	untilQuota, haveQuota := 0.0, false
+	for _, dl := range datasetLimits {
+		limitProp, ok := props[dl.prop]
+		if !ok {
+			continue
+		}
+		limit, ok, err := parsePropNumber(limitProp.Value)
+		if err != nil {
+			return err
+		}
+		if !ok || limit == 0 {
+			continue
+		}
+		usage, ok, err := parsePropNumber(props[dl.usage].Value)
+		if err != nil {
+			return err
+		}
+
+		ch <- prometheus.MustNewConstMetric(dl.desc, prometheus.GaugeValue, limit, labelValues...)
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(dl.ratioDesc, prometheus.GaugeValue, usage/limit, labelValues...)
+		if dl.prop == zfs.DatasetPropQuota || dl.prop == zfs.DatasetPropRefquota {
+			if left := limit - usage; !haveQuota || left < untilQuota {
+				untilQuota, haveQuota = left, true
+			}
+		}
+	}
+	if haveQuota {
+		ch <- prometheus.MustNewConstMetric(datasetuntilquotaDesc, prometheus.GaugeValue, untilQuota, labelValues...)
+	}
+	return nil
+}
+
+// visitDatasets calls visitor on d and all its descendants.
+func visitDatasets(d DatasetHandle, visitor func(d DatasetHandle)) {
+	visitor(d)
+	for _, child := range d.Children() {
+		visitDatasets(child, visitor)
+	}
+}
+
+// datasetTypeName returns the name zfs(8) uses for t.
+func datasetTypeName(t zfs.DatasetType) string {
+	switch t {
+	case zfs.DatasetTypeFilesystem:
+		return "filesystem"
+	case zfs.DatasetTypeSnapshot:
+		return "snapshot"
+	case zfs.DatasetTypeVolume:
+		return "volume"
+	case zfs.DatasetTypeBookmark:
+		return "bookmark"
+	}
+	return "unknown"
+}
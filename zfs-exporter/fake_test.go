@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ncabatoff/go-libzfs"
+)
+
+type (
+	// fakeBackend is an in-memory Backend.  OpenPools returns the fakePool
+	// values it holds, so callers can mutate them between scrapes.
+	fakeBackend struct {
+		pools   []*fakePool
+		openErr error
+		// opens counts OpenPools calls; use sync/atomic to read it.
+		opens int32
+		// gate, if set, makes OpenPools wait until it's closed.
+		gate chan struct{}
+
+		importable []zfs.ExportedPool
+		importErr  error
+	}
+
+	// fakePool is an in-memory PoolHandle.  Each of the *Err fields, when set,
+	// is returned by the corresponding method along with the canned value.
+	fakePool struct {
+		name   string
+		state  zfs.PoolState
+		status zfs.PoolStatus
+		vdevs  zfs.VDevTree
+		props  map[zfs.Prop]string
+		feats  map[string]string
+		root   *fakeDataset // created on first use if nil
+		// block, if set, makes RefreshStats hang until it's closed, like a
+		// pool suspended in the kernel.
+		block chan struct{}
+		// refreshes counts RefreshStats calls; use sync/atomic to read it.
+		refreshes int32
+
+		refreshErr error
+		vdevErr    error
+		stateErr   error
+		statusErr  error
+		propsErr   error
+		dsErr      error
+
+		closes int
+	}
+
+	// fakeDataset is an in-memory DatasetHandle.  namedProps holds the
+	// properties only available through PropertyByName or UserProperty.
+	fakeDataset struct {
+		typ        zfs.DatasetType
+		props      map[zfs.Prop]string
+		namedProps map[string]string
+		mounted    bool
+		children   []*fakeDataset
+
+		closes int
+	}
+)
+
+// OpenPools implements Backend.
+func (f *fakeBackend) OpenPools() ([]PoolHandle, error) {
+	atomic.AddInt32(&f.opens, 1)
+	if f.gate != nil {
+		<-f.gate
+	}
+	if f.openErr != nil {
+		return nil, f.openErr
+	}
+	handles := make([]PoolHandle, 0, len(f.pools))
+	for _, p := range f.pools {
+		handles = append(handles, p)
+	}
+	return handles, nil
+}
+
+// ImportablePools implements Backend.
+func (f *fakeBackend) ImportablePools(searchPaths []string) ([]zfs.ExportedPool, error) {
+	return f.importable, f.importErr
+}
+
+func (p *fakePool) Name() string {
+	return p.name
+}
+
+func (p *fakePool) GUID() uint64 {
+	guid, _ := strconv.ParseUint(p.props[zfs.PoolPropGUID], 10, 64)
+	return guid
+}
+
+func (p *fakePool) RefreshStats() error {
+	atomic.AddInt32(&p.refreshes, 1)
+	if p.block != nil {
+		<-p.block
+	}
+	return p.refreshErr
+}
+
+func (p *fakePool) VDevTree() (zfs.VDevTree, error) {
+	return p.vdevs, p.vdevErr
+}
+
+func (p *fakePool) State() (zfs.PoolState, error) {
+	return p.state, p.stateErr
+}
+
+func (p *fakePool) Status() (zfs.PoolStatus, error) {
+	return p.status, p.statusErr
+}
+
+func (p *fakePool) GetProperty(prop zfs.Prop) (zfs.Property, error) {
+	return zfs.Property{Value: p.props[prop], Source: "none"}, nil
+}
+
+func (p *fakePool) ReloadProperties() error {
+	return p.propsErr
+}
+
+func (p *fakePool) Properties() []zfs.Property {
+	props := make([]zfs.Property, zfs.PoolNumProps+1)
+	for prop, value := range p.props {
+		props[prop] = zfs.Property{Value: value, Source: "none"}
+	}
+	return props
+}
+
+func (p *fakePool) Features() map[string]string {
+	return p.feats
+}
+
+func (p *fakePool) OpenDatasets() (DatasetHandle, error) {
+	if p.dsErr != nil {
+		return nil, p.dsErr
+	}
+	if p.root == nil {
+		// Every pool has at least its root filesystem.
+		p.root = &fakeDataset{
+			typ:   zfs.DatasetTypeFilesystem,
+			props: map[zfs.Prop]string{zfs.DatasetPropName: p.name},
+		}
+	}
+	return p.root, nil
+}
+
+func (p *fakePool) Close() {
+	p.closes++
+}
+
+func (d *fakeDataset) Type() zfs.DatasetType {
+	return d.typ
+}
+
+func (d *fakeDataset) Properties() map[zfs.Prop]zfs.Property {
+	props := make(map[zfs.Prop]zfs.Property, len(d.props))
+	for prop, value := range d.props {
+		props[prop] = zfs.Property{Value: value, Source: "local"}
+	}
+	return props
+}
+
+func (d *fakeDataset) PropertyByName(name string) (zfs.Property, error) {
+	value, ok := d.namedProps[name]
+	if !ok {
+		return zfs.Property{}, fmt.Errorf("unknown property %s", name)
+	}
+	return zfs.Property{Value: value, Source: "local"}, nil
+}
+
+func (d *fakeDataset) UserProperty(name string) (zfs.Property, error) {
+	return d.PropertyByName(name)
+}
+
+func (d *fakeDataset) IsMounted() (bool, string) {
+	if !d.mounted {
+		return false, ""
+	}
+	return true, d.props[zfs.DatasetPropMountpoint]
+}
+
+func (d *fakeDataset) Children() []DatasetHandle {
+	children := make([]DatasetHandle, 0, len(d.children))
+	for _, child := range d.children {
+		children = append(children, child)
+	}
+	return children
+}
+
+func (d *fakeDataset) Close() {
+	d.closes++
+}
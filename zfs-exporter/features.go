@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	featureStateNames = []string{
+		"disabled",
+		"enabled",
+		"active",
+	}
+
+	featurestateDesc = prometheus.NewDesc(
+		"zfs_zpool_feature_state",
+		"one series per feature flag and state (disabled, enabled, active), 1 for the feature's current state and 0 for the others.",
+		[]string{"poolname", "feature", "state"},
+		nil)
+)
+
+// featuresCollector reports the state of each pool feature flag.
+type featuresCollector struct{}
+
+func init() {
+	registerCollector("features", true, func() subCollector { return featuresCollector{} })
+}
+
+// Describe implements subCollector.
+func (featuresCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- featurestateDesc
+}
+
+// Update implements subCollector.
+func (featuresCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	if err := ps.ReloadProperties(); err != nil {
+		return fmt.Errorf("unable to reload properties: %v", err)
+	}
+	features := ps.pool.Features()
+
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	for _, name := range names {
+		state := features[name]
+		known := false
+		for _, stateName := range featureStateNames {
+			value := 0.0
+			if stateName == state {
+				value, known = 1, true
+			}
+			ch <- prometheus.MustNewConstMetric(featurestateDesc,
+				prometheus.GaugeValue,
+				value,
+				ps.poolName, name, stateName)
+		}
+		if !known && firstErr == nil {
+			firstErr = fmt.Errorf("feature %s has unknown state %q", name, state)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// importSearchPaths and importSearchInterval configure the importable
+	// collector.  They're set from -zfs.import-search-paths and
+	// -zfs.import-search-interval before the collectors are created.
+	importSearchPaths    []string
+	importSearchInterval = 5 * time.Minute
+
+	importableDesc = prometheus.NewDesc(
+		"zfs_zpool_importable",
+		"always 1; one series per pool found on the devices searched that isn't imported, e.g. because it failed to import at boot. status says what zpool import would report about it.",
+		[]string{"poolname", "guid", "status"},
+		nil)
+
+	importablescanDesc = prometheus.NewDesc(
+		"zfs_zpool_importable_last_scan_timestamp_seconds",
+		"unix time at which the last successful search for importable pools finished.",
+		nil,
+		nil)
+)
+
+// importableCollector reports pools that could be imported but aren't.
+// Searching means reading the label of every device in the search paths,
+// which is slow and could hang on a failing disk, so it's done in the
+// background every interval and Update serves the outcome of the last search.
+type importableCollector struct {
+	searchPaths []string
+	interval    time.Duration
+
+	mu        sync.Mutex
+	searching bool
+	lastStart time.Time
+	lastEnd   time.Time
+	pools     []zfs.ExportedPool
+	err       error
+}
+
+func init() {
+	registerHostCollector("importable", false, func() hostCollector {
+		return &importableCollector{searchPaths: importSearchPaths, interval: importSearchInterval}
+	})
+}
+
+// Describe implements hostCollector.
+func (c *importableCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- importableDesc
+	ch <- importablescanDesc
+}
+
+// Update implements hostCollector.
+func (c *importableCollector) Update(hs *hostScrape, ch chan<- prometheus.Metric) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.searching && time.Since(c.lastStart) >= c.interval {
+		c.searching = true
+		c.lastStart = time.Now()
+		go c.search(hs.backend)
+	}
+
+	if !c.lastEnd.IsZero() {
+		ch <- prometheus.MustNewConstMetric(importablescanDesc,
+			prometheus.GaugeValue,
+			float64(c.lastEnd.UnixNano())/1e9)
+	}
+	for _, ep := range c.pools {
+		ch <- prometheus.MustNewConstMetric(importableDesc,
+			prometheus.GaugeValue,
+			1,
+			ep.Name, fmt.Sprintf("%d", ep.GUID), enumName(poolStatusNames, uint64(ep.Status)))
+	}
+	if c.err != nil {
+		return fmt.Errorf("error searching for importable pools: %v", c.err)
+	}
+	return nil
+}
+
+// search looks for importable pools and records the outcome.  On error the
+// pools found by the previous search are kept.
+func (c *importableCollector) search(backend Backend) {
+	pools, err := backend.ImportablePools(c.searchPaths)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.searching = false
+	c.err = err
+	if err != nil {
+		return
+	}
+	c.pools = pools
+	c.lastEnd = time.Now()
+}
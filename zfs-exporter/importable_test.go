@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestImportableCollector checks that the importable collector searches in
+// the background, reports the pools the last search found along with their
+// status, and keeps them when a later search fails.
+func TestImportableCollector(t *testing.T) {
+	fb := &fakeBackend{importable: []zfs.ExportedPool{
+		{Name: "backup", GUID: 18446744073709551557, Status: zfs.PoolStatusOk},
+		{Name: "old", GUID: 42, Status: zfs.PoolStatusHostidMismatch},
+	}}
+	c := &importableCollector{}
+	hs := &hostScrape{backend: fb}
+	want := map[string][2]string{
+		"backup": {"18446744073709551557", "ok"},
+		"old":    {"42", "hostid_mismatch"},
+	}
+
+	// The first update only starts the search.
+	if got, scanned, err := updateImportable(t, c, hs); len(got) != 0 || scanned || err != nil {
+		t.Errorf("first update got %v, scanned %v, error %v; want nothing", got, scanned, err)
+	}
+	waitForSearch(t, c)
+	if got, scanned, err := updateImportable(t, c, hs); !reflect.DeepEqual(got, want) || !scanned || err != nil {
+		t.Errorf("got %v, scanned %v, error %v; want %v", got, scanned, err, want)
+	}
+	waitForSearch(t, c)
+
+	// A failed search is reported, but the pools found before are kept.
+	fb.importable, fb.importErr = nil, errors.New("no such device")
+	updateImportable(t, c, hs)
+	waitForSearch(t, c)
+	if got, scanned, err := updateImportable(t, c, hs); !reflect.DeepEqual(got, want) || !scanned || err == nil {
+		t.Errorf("after failed search got %v, scanned %v, error %v; want %v and an error", got, scanned, err, want)
+	}
+}
+
+// updateImportable runs c.Update and returns the guid and status labels of
+// each pool reported, by name, and whether the last scan time was reported.
+func updateImportable(t *testing.T, c *importableCollector, hs *hostScrape) (pools map[string][2]string, scanned bool, err error) {
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Update(hs, ch)
+		close(ch)
+	}()
+	pools = map[string][2]string{}
+	for m := range ch {
+		if m.Desc() == importablescanDesc {
+			scanned = true
+			continue
+		}
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, lp := range pb.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		pools[labels["poolname"]] = [2]string{labels["guid"], labels["status"]}
+	}
+	return pools, scanned, <-errc
+}
+
+// waitForSearch waits for the background search started by c.Update to
+// finish.
+func waitForSearch(t *testing.T, c *importableCollector) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		searching := c.searching
+		c.mu.Unlock()
+		if !searching {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("search didn't finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -6,86 +6,102 @@ import (
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/ncabatoff/go-libzfs"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	zioTypeNames = []string{
-		"Null",
-		"Read",
-		"Write",
-		"Free",
-		"Claim",
-		"IoCtl",
-	}
-
-	vdevopsDesc = prometheus.NewDesc(
-		"zfs_zpool_vdevops_total",
-		"number of operations performed.",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "vdevoptype"},
-		nil)
-
-	vdevbytesDesc = prometheus.NewDesc(
-		"zfs_zpool_vdevbytes_total",
-		"number of bytes handled",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "vdevoptype"},
-		nil)
-
-	vdeverrorsDesc = prometheus.NewDesc(
-		"zfs_zpool_errors_total",
-		"number of errors seen",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "errortype"},
-		nil)
-
-	vdevstateDesc = prometheus.NewDesc(
-		"zfs_zpool_vdevstate",
-		"vdev state: Unknown, Closed, Offline, Removed, CantOpen, Faulted, Degraded, Healthy.",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid"},
-		nil)
-
-	vdevallocDesc = prometheus.NewDesc(
-		"zfs_zpool_allocated_bytes",
-		"number of bytes allocated (usage)",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid"},
-		nil)
-
-	vdevspaceDesc = prometheus.NewDesc(
-		"zfs_zpool_space_bytes",
-		"size of the vdev in bytes (total capacity).",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid"},
-		nil)
-
-	vdevfragDesc = prometheus.NewDesc(
-		"zfs_zpool_fragmentation_percent",
-		"device fragmentation percentage",
-		[]string{"poolname", "vdevtype", "vdevname", "vdevid"},
+	collecterrsDesc = prometheus.NewDesc(
+		"zfs_zpool_collecterrors",
+		"errors harvesting ZFS metrics",
+		[]string{"poolname"},
 		nil)
 
-	poolstateDesc = prometheus.NewDesc(
-		"zfs_zpool_poolstate",
-		"pool state enum: Active, Exported, Destroyed, Spare, L2cache, uninitialized, unavail, potentiallyactive",
+	collecttimeoutDesc = prometheus.NewDesc(
+		"zfs_zpool_collect_timeout",
+		"1 if the pool didn't respond within -zfs.pool-timeout and its metrics were skipped.",
 		[]string{"poolname"},
 		nil)
 
-	poolstatusDesc = prometheus.NewDesc(
-		"zfs_zpool_poolstatus",
-		"pool status enum: CorruptCache, MissingDevR, MissingDevNr, CorruptLabelR, CorruptLabelNr, BadGUIDSum, CorruptPool, CorruptData, FailingDev, VersionNewer, HostidMismatch, IoFailureWait, IoFailureContinue, BadLog, Errata, UnsupFeatRead, UnsupFeatWrite, FaultedDevR, FaultedDevNr, VersionOlder, FeatDisabled, Resilvering, OfflineDev, RemovedDev, Ok",
+	lastrefreshDesc = prometheus.NewDesc(
+		"zfs_zpool_last_refresh_timestamp_seconds",
+		"unix time at which the pool was last collected in time and without errors.",
 		[]string{"poolname"},
 		nil)
 
-	collecterrsDesc = prometheus.NewDesc(
-		"zfs_zpool_collecterrors",
-		"errors harvesting ZFS metrics",
+	refreshageDesc = prometheus.NewDesc(
+		"zfs_zpool_refresh_age_seconds",
+		"seconds since the pool was last collected in time and without errors.",
 		[]string{"poolname"},
 		nil)
 )
 
 type (
+	// CollectorOpts configures a ZfsCollector.
+	CollectorOpts struct {
+		// DiscoveryInterval is how long the set of pools is cached before
+		// being rediscovered.  Zero means rediscover on every scrape.
+		DiscoveryInterval time.Duration
+		// PoolTimeout bounds how long a scrape waits for any one pool.
+		// Zero means wait forever.
+		PoolTimeout time.Duration
+		// PollInterval, if nonzero, makes Start refresh the pools in the
+		// background at this interval, with Collect serving the most recent
+		// snapshot.  Otherwise the pools are refreshed by every Collect.
+		PollInterval time.Duration
+		// Collectors are the sub-collectors run against each pool, keyed by
+		// name.
+		Collectors map[string]subCollector
+		// HostCollectors are run once per refresh, keyed by name.
+		HostCollectors map[string]hostCollector
+	}
+
 	ZfsCollector struct {
-		pools    []zfs.Pool
-		poolerrs map[string]int
+		CollectorOpts
+		backend Backend
+
+		// refreshMu serializes refresh, and guards everything from here
+		// down to mu.
+		refreshMu     sync.Mutex
+		pools         []PoolHandle
+		poolerrs      map[string]int
+		lastSuccess   map[string]time.Time
+		lastDiscovery time.Time
+		// pending holds the result channel of each pool collection that
+		// missed its deadline and hasn't finished yet.  Until it does, the
+		// pool isn't touched again: a pool suspended in the kernel would
+		// just swallow another goroutine.
+		pending map[PoolHandle]chan poolResult
+
+		// mu guards the fields below it.
+		mu             sync.Mutex
+		snapshot       []poolSnapshot
+		hostMetrics    []prometheus.Metric
+		collectorStats collectorStats
+		// inflight is closed when the on-demand refresh currently in
+		// progress, if any, completes.
+		inflight chan struct{}
+	}
+
+	// poolResult is what a single pool's collection produced.
+	poolResult struct {
+		metrics []prometheus.Metric
+		errs    int
+		stats   collectorStats
+	}
+
+	// poolSnapshot is everything Collect reports about a pool as of the last
+	// refresh.
+	poolSnapshot struct {
+		poolName    string
+		metrics     []prometheus.Metric
+		errs        int
+		timedout    bool
+		lastSuccess time.Time
 	}
 )
 
@@ -93,15 +109,36 @@ func main() {
 	var (
 		listenAddress = flag.String("web.listen-address", ":9254", "Address on which to expose metrics and web interface.")
 		metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		discoveryIntv = flag.Duration("zfs.discovery-interval", 0, "How often to look for imported/exported pools; 0 means on every scrape.")
+		poolTimeout   = flag.Duration("zfs.pool-timeout", 5*time.Second, "How long to wait for a pool's metrics before skipping it; 0 means wait forever.")
+		pollInterval  = flag.Duration("zfs.poll-interval", 0, "If nonzero, refresh pools in the background at this interval and serve cached metrics; otherwise refresh on every scrape.")
+		snapPrefixes  = flag.String("zfs.snapshot-prefixes", "", "Comma-separated snapshot name prefixes, e.g. autosnap_,manual-, by which the snapshot collector groups snapshots.")
+		importPaths   = flag.String("zfs.import-search-paths", "", "Comma-separated directories the importable collector searches for pool devices; empty means the libzfs default, /dev.")
+		cachefile     = flag.String("zfs.cachefile", cacheFilePath, "Pool cache file the cachefile collector reads the pools expected to be imported from.")
+		importIntv    = flag.Duration("zfs.import-search-interval", importSearchInterval, "How often the importable collector searches for importable pools.")
+		userProps     = flag.String("zfs.user-properties", "", "Comma-separated user properties, e.g. com.acme:owner,com.acme:tenant, the dataset collector exports as zfs_dataset_user_property_info.")
 	)
+	registerCollectorFlags(flag.CommandLine)
 	flag.Parse()
 
-	z := NewZfsCollector()
+	snapshotPrefixes = splitList(*snapPrefixes)
+	datasetUserProperties = splitList(*userProps)
+	importSearchPaths, importSearchInterval = splitList(*importPaths), *importIntv
+	cacheFilePath = *cachefile
+
+	z := NewZfsCollector(libzfsBackend{}, CollectorOpts{
+		DiscoveryInterval: *discoveryIntv,
+		PoolTimeout:       *poolTimeout,
+		PollInterval:      *pollInterval,
+		Collectors:        enabledCollectors(),
+		HostCollectors:    enabledHostCollectors(),
+	})
 	err := z.Init()
 	if err != nil {
 		log.Printf("%s", err)
 		return
 	}
+	z.Start()
 	prometheus.MustRegister(z)
 
 	http.Handle(*metricsPath, prometheus.Handler())
@@ -118,141 +155,374 @@ func main() {
 	http.ListenAndServe(*listenAddress, nil)
 }
 
-func poolname(pool zfs.Pool) string {
-	return pool.Properties[zfs.PoolPropName].Value
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // Describe implements prometheus.Collector.
 func (z *ZfsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- vdevopsDesc
-	ch <- vdevbytesDesc
-	ch <- vdeverrorsDesc
-	ch <- vdevstateDesc
-	ch <- vdevallocDesc
-	ch <- vdevspaceDesc
-	ch <- vdevfragDesc
-	ch <- poolstateDesc
-	ch <- poolstatusDesc
-	// TODO add error metric
+	for _, c := range z.Collectors {
+		c.Describe(ch)
+	}
+	for _, c := range z.HostCollectors {
+		c.Describe(ch)
+	}
+	ch <- collecterrsDesc
+	ch <- collecttimeoutDesc
+	ch <- lastrefreshDesc
+	ch <- refreshageDesc
+	ch <- collectorDurationDesc
+	ch <- collectorSuccessDesc
 }
 
-func NewZfsCollector() *ZfsCollector {
-	return &ZfsCollector{poolerrs: make(map[string]int)}
+func NewZfsCollector(backend Backend, opts CollectorOpts) *ZfsCollector {
+	return &ZfsCollector{
+		CollectorOpts: opts,
+		backend:       backend,
+		poolerrs:      make(map[string]int),
+		lastSuccess:   make(map[string]time.Time),
+		pending:       make(map[PoolHandle]chan poolResult),
+	}
 }
 
 func (z *ZfsCollector) Init() error {
-	pools, err := zfs.PoolOpenAll()
+	z.refreshMu.Lock()
+	defer z.refreshMu.Unlock()
+	return z.discoverPools()
+}
+
+// Start begins background polling if z.PollInterval is set.  The first
+// refresh is done before returning, so there's data for the first scrape.
+func (z *ZfsCollector) Start() {
+	if z.PollInterval <= 0 {
+		return
+	}
+	z.refresh()
+	go func() {
+		for range time.Tick(z.PollInterval) {
+			z.refresh()
+		}
+	}()
+}
+
+// discoverPools updates z.pools to match the pools currently imported.
+// Handles for pools we already know are kept.  Handles for pools that have
+// gone away, or been replaced by another pool of the same name, are closed,
+// and their error counts, and whatever sub-collectors remember about them,
+// forgotten so that their series disappear.  On error the previous set of
+// pools is kept.
+func (z *ZfsCollector) discoverPools() error {
+	opened, err := z.backend.OpenPools()
 	if err != nil {
 		return fmt.Errorf("error opening pools: %v", err)
 	}
+
+	known := make(map[string]PoolHandle, len(z.pools))
+	for _, pool := range z.pools {
+		known[pool.Name()] = pool
+	}
+
+	pools := make([]PoolHandle, 0, len(opened))
+	kept := make(map[string]bool, len(opened))
+	for _, pool := range opened {
+		poolName := pool.Name()
+		if old, ok := known[poolName]; ok && old.GUID() == pool.GUID() {
+			pool.Close()
+			pool = old
+			kept[poolName] = true
+		}
+		pools = append(pools, pool)
+	}
+
+	for poolName, pool := range known {
+		if !kept[poolName] {
+			z.closePool(pool)
+			delete(z.poolerrs, poolName)
+			delete(z.lastSuccess, poolName)
+			for _, c := range z.Collectors {
+				if f, ok := c.(poolForgetter); ok {
+					f.forgetPool(poolName)
+				}
+			}
+		}
+	}
 	z.pools = pools
+	z.lastDiscovery = time.Now()
 	return nil
 }
 
-// Collect implements prometheus.Collector.
-func (z *ZfsCollector) Collect(ch chan<- prometheus.Metric) {
-	for _, pool := range z.pools {
-		// log.Printf("collecting pool %s", poolname(pool))
-		z.collectPool(ch, pool)
+// closePool closes the handle of a pool that has gone away.  If a collection
+// is still running against it, closing is left to that collection's goroutine.
+func (z *ZfsCollector) closePool(pool PoolHandle) {
+	c, ok := z.pending[pool]
+	if !ok {
+		pool.Close()
+		return
 	}
+	delete(z.pending, pool)
+	go func() {
+		<-c
+		pool.Close()
+	}()
 }
 
-func (z *ZfsCollector) collectPool(ch chan<- prometheus.Metric, pool zfs.Pool) {
-	poolName := poolname(pool)
-	if _, ok := z.poolerrs[poolName]; !ok {
-		z.poolerrs[poolName] = 0
+// Collect implements prometheus.Collector.
+func (z *ZfsCollector) Collect(ch chan<- prometheus.Metric) {
+	if z.PollInterval <= 0 {
+		z.sharedRefresh()
 	}
 
-	err := pool.RefreshStats()
-	if err != nil {
-		log.Printf("unable to refresh status for pool '%s': %v", poolName, err)
-		z.poolerrs[poolName]++
+	z.mu.Lock()
+	snapshot, hostMetrics, stats := z.snapshot, z.hostMetrics, z.collectorStats
+	z.mu.Unlock()
+
+	stats.collect(ch)
+	for _, m := range hostMetrics {
+		ch <- m
 	}
 
-	var vdt zfs.VDevTree
-	if err == nil {
-		ch <- prometheus.MustNewConstMetric(poolstateDesc,
-			prometheus.GaugeValue,
-			poolstate(pool),
-			poolName)
+	now := time.Now()
+	for _, ps := range snapshot {
+		for _, m := range ps.metrics {
+			ch <- m
+		}
 
-		ch <- prometheus.MustNewConstMetric(poolstatusDesc,
-			prometheus.GaugeValue,
-			poolstatus(pool),
-			poolName)
+		ch <- prometheus.MustNewConstMetric(collecterrsDesc,
+			prometheus.CounterValue,
+			float64(ps.errs),
+			ps.poolName)
 
-		vdt, err = pool.VDevTree()
-		if err != nil {
-			log.Printf("unable to read vdevtree for pool '%s': %v", poolName, err)
-			z.poolerrs[poolName]++
+		timedout := 0.0
+		if ps.timedout {
+			timedout = 1
+		}
+		ch <- prometheus.MustNewConstMetric(collecttimeoutDesc,
+			prometheus.GaugeValue,
+			timedout,
+			ps.poolName)
+
+		if !ps.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastrefreshDesc,
+				prometheus.GaugeValue,
+				float64(ps.lastSuccess.UnixNano())/1e9,
+				ps.poolName)
+
+			ch <- prometheus.MustNewConstMetric(refreshageDesc,
+				prometheus.GaugeValue,
+				now.Sub(ps.lastSuccess).Seconds(),
+				ps.poolName)
 		}
 	}
+}
 
-	ch <- prometheus.MustNewConstMetric(collecterrsDesc,
-		prometheus.CounterValue,
-		float64(z.poolerrs[poolName]),
-		poolName)
-
-	if err != nil {
+// sharedRefresh calls refresh, unless one started by another Collect is
+// already running, in which case it waits for that one to finish instead.
+// Concurrent scrapes thus share a single pass over the pools.
+func (z *ZfsCollector) sharedRefresh() {
+	z.mu.Lock()
+	if done := z.inflight; done != nil {
+		z.mu.Unlock()
+		<-done
 		return
 	}
+	done := make(chan struct{})
+	z.inflight = done
+	z.mu.Unlock()
+
+	z.refresh()
 
-	visitVdevs(pool, vdt, func(pool zfs.Pool, vdt zfs.VDevTree) {
-		vType := string(vdt.Type)
-		// log.Printf("visiting pool %s vdev %s id %d type %s path %s", poolname(pool), vdt.Name, vdt.Id, vType, vdt.Path)
-
-		id := fmt.Sprintf("%d", vdt.Id)
-		ch <- prometheus.MustNewConstMetric(vdevstateDesc, prometheus.GaugeValue,
-			float64(vdt.Stat.State), poolName, vType, vdt.Name, id)
-		ch <- prometheus.MustNewConstMetric(vdevallocDesc, prometheus.GaugeValue,
-			float64(vdt.Stat.Alloc), poolName, vType, vdt.Name, id)
-		ch <- prometheus.MustNewConstMetric(vdevspaceDesc, prometheus.GaugeValue,
-			float64(vdt.Stat.Space), poolName, vType, vdt.Name, id)
-		ch <- prometheus.MustNewConstMetric(vdevfragDesc, prometheus.GaugeValue,
-			float64(vdt.Stat.Fragmentation), poolName, vType, vdt.Name, id)
-
-		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
-			float64(vdt.Stat.ReadErrors), poolName, vType, vdt.Name, id, "read")
-		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
-			float64(vdt.Stat.WriteErrors), poolName, vType, vdt.Name, id, "write")
-		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
-			float64(vdt.Stat.ChecksumErrors), poolName, vType, vdt.Name, id, "checksum")
-
-		for optype := zfs.ZIOTypeRead; optype < zfs.ZIOTypes; optype++ {
-			ch <- prometheus.MustNewConstMetric(vdevopsDesc, prometheus.CounterValue,
-				float64(vdt.Stat.Ops[optype]),
-				poolName, vType, vdt.Name, id, zioTypeNames[optype])
+	z.mu.Lock()
+	z.inflight = nil
+	z.mu.Unlock()
+	close(done)
+}
+
+// refresh rediscovers pools if it's time to, collects them and the host
+// collectors, and replaces z.snapshot and z.hostMetrics with the outcome.
+func (z *ZfsCollector) refresh() {
+	z.refreshMu.Lock()
+	defer z.refreshMu.Unlock()
+
+	if time.Since(z.lastDiscovery) >= z.DiscoveryInterval {
+		if err := z.discoverPools(); err != nil {
+			log.Printf("%v", err)
 		}
+	}
 
-		for optype := zfs.ZIOTypeRead; optype < zfs.ZIOTypes; optype++ {
-			ch <- prometheus.MustNewConstMetric(vdevbytesDesc, prometheus.CounterValue,
-				float64(vdt.Stat.Bytes[optype]),
-				poolName, vType, vdt.Name, id, zioTypeNames[optype])
+	results := z.collectPools()
+	now := time.Now()
+	snapshot := make([]poolSnapshot, 0, len(z.pools))
+	stats := make(collectorStats)
+	for _, pool := range z.pools {
+		poolName := pool.Name()
+		ps := poolSnapshot{poolName: poolName, timedout: true}
+		if r, ok := results[pool]; ok {
+			ps.timedout = false
+			ps.metrics = r.metrics
+			stats.add(r.stats)
+			z.poolerrs[poolName] += r.errs
+			if r.errs == 0 {
+				z.lastSuccess[poolName] = now
+			}
+		} else {
+			// The pool didn't finish, so none of its collectors succeeded.
+			for name := range z.Collectors {
+				stat := stats[name]
+				stat.failed = true
+				stats[name] = stat
+			}
 		}
-	})
+		ps.errs = z.poolerrs[poolName]
+		ps.lastSuccess = z.lastSuccess[poolName]
+		snapshot = append(snapshot, ps)
+	}
+
+	hostMetrics := z.collectHost(stats)
+
+	z.mu.Lock()
+	z.snapshot, z.hostMetrics, z.collectorStats = snapshot, hostMetrics, stats
+	z.mu.Unlock()
 }
 
-func poolstatus(pool zfs.Pool) float64 {
-	pstatus, err := pool.Status()
-	if err != nil {
-		log.Printf("error getting status of pool '%s': %v\n", poolname(pool), err)
-		return -1
+// collectHost runs each host collector, recording how it fared in stats, and
+// returns the metrics they produced.  Host collectors are expected to not
+// block on anything that might hang, so there's no timeout here.
+func (z *ZfsCollector) collectHost(stats collectorStats) []prometheus.Metric {
+	names := make([]string, 0, len(z.HostCollectors))
+	for name := range z.HostCollectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hs := &hostScrape{backend: z.backend}
+	for _, pool := range z.pools {
+		hs.poolGUIDs = append(hs.poolGUIDs, pool.GUID())
 	}
-	return float64(pstatus)
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+
+	for _, name := range names {
+		start := time.Now()
+		err := z.HostCollectors[name].Update(hs, ch)
+		stats[name] = collectorStat{duration: time.Since(start), failed: err != nil}
+		if err != nil {
+			log.Printf("%s collector failed: %v", name, err)
+		}
+	}
+	close(ch)
+	return <-done
 }
 
-func poolstate(pool zfs.Pool) float64 {
-	pstate, err := pool.State()
-	if err != nil {
-		log.Printf("error getting state of pool '%s': %v\n", poolname(pool), err)
-		return -1
+// collectPools collects every pool in its own goroutine and returns the
+// results of those that finish within z.PoolTimeout.  Pools that miss the
+// deadline are absent from the returned map.
+func (z *ZfsCollector) collectPools() map[PoolHandle]poolResult {
+	inflight := make(map[PoolHandle]chan poolResult, len(z.pools))
+	for _, pool := range z.pools {
+		if c, ok := z.pending[pool]; ok {
+			select {
+			case <-c:
+				// Finished since the last scrape; that result is stale by
+				// now, so collect afresh below.
+				delete(z.pending, pool)
+			default:
+				log.Printf("pool '%s' is still busy with a previous collection", pool.Name())
+				continue
+			}
+		}
+
+		c := make(chan poolResult, 1)
+		go func(pool PoolHandle) {
+			c <- z.gatherPool(pool)
+		}(pool)
+		inflight[pool] = c
+	}
+
+	var deadline <-chan time.Time
+	if z.PoolTimeout > 0 {
+		deadline = time.After(z.PoolTimeout)
+	}
+	expired := false
+	results := make(map[PoolHandle]poolResult, len(inflight))
+	for pool, c := range inflight {
+		if !expired {
+			select {
+			case r := <-c:
+				results[pool] = r
+				continue
+			case <-deadline:
+				expired = true
+			}
+		}
+		select {
+		case r := <-c:
+			results[pool] = r
+		default:
+			log.Printf("timed out collecting pool '%s'", pool.Name())
+			z.pending[pool] = c
+		}
 	}
-	return float64(pstate)
+	return results
 }
 
-func visitVdevs(pool zfs.Pool, vdt zfs.VDevTree, visitor func(pool zfs.Pool, vdt zfs.VDevTree)) {
-	visitor(pool, vdt)
-	for _, child := range vdt.Devices {
-		visitVdevs(pool, child, visitor)
+// gatherPool runs collectPool and buffers the metrics it produces.
+func (z *ZfsCollector) gatherPool(pool PoolHandle) poolResult {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+
+	errs, stats := z.collectPool(ch, pool)
+	close(ch)
+	return poolResult{metrics: <-done, errs: errs, stats: stats}
+}
+
+// collectPool refreshes the pool's stats and runs each sub-collector against
+// it, sending the metrics to ch.  It returns the number of errors encountered
+// along the way and how each sub-collector fared.
+func (z *ZfsCollector) collectPool(ch chan<- prometheus.Metric, pool PoolHandle) (int, collectorStats) {
+	poolName := pool.Name()
+	stats := make(collectorStats, len(z.Collectors))
+
+	if err := pool.RefreshStats(); err != nil {
+		log.Printf("unable to refresh status for pool '%s': %v", poolName, err)
+		for name := range z.Collectors {
+			stats[name] = collectorStat{failed: true}
+		}
+		return 1, stats
+	}
+
+	errs := 0
+	ps := &poolScrape{pool: pool, poolName: poolName}
+	defer ps.Close()
+	for _, name := range collectorNames(z.Collectors) {
+		start := time.Now()
+		err := z.Collectors[name].Update(ps, ch)
+		stats[name] = collectorStat{duration: time.Since(start), failed: err != nil}
+		if err != nil {
+			log.Printf("%s collector failed for pool '%s': %v", name, poolName, err)
+			errs++
+		}
 	}
+	return errs, stats
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestTimedOutPoolFailsCollectors checks that a pool which misses its
+// deadline shows up as a failure of every collector, even though the other
+// pool was collected fine.
+func TestTimedOutPoolFailsCollectors(t *testing.T) {
+	hung := healthyPool()
+	hung.name = "hung"
+	hung.block = make(chan struct{})
+	defer close(hung.block)
+
+	z := NewZfsCollector(&fakeBackend{pools: []*fakePool{healthyPool(), hung}}, CollectorOpts{
+		PoolTimeout: 50 * time.Millisecond,
+		Collectors:  enabledCollectors(),
+	})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	out := string(scrape(t, z))
+
+	if !strings.Contains(out, `zfs_zpool_collect_timeout{poolname="hung"} 1`) {
+		t.Errorf("hung pool not reported as timed out:\n%s", out)
+	}
+	for name := range z.Collectors {
+		line := `zfs_exporter_collector_success{collector="` + name + `"} 0`
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
+
+// TestConcurrentCollectsShareRefresh checks that scrapes arriving while a
+// refresh is running wait for it rather than starting their own, so the
+// pools are only discovered once per refresh.
+func TestConcurrentCollectsShareRefresh(t *testing.T) {
+	fb := &fakeBackend{pools: []*fakePool{healthyPool()}}
+	z := NewZfsCollector(fb, CollectorOpts{PoolTimeout: time.Second, Collectors: enabledCollectors()})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&fb.opens, 0)
+	fb.gate = make(chan struct{})
+
+	const scrapes = 10
+	var wg sync.WaitGroup
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectAll(z)
+		}()
+	}
+	// Hold the first refresh in OpenPools until the other scrapes have had
+	// plenty of time to pile up behind it.
+	time.Sleep(100 * time.Millisecond)
+	close(fb.gate)
+	wg.Wait()
+	if n := atomic.LoadInt32(&fb.opens); n != 1 {
+		t.Errorf("OpenPools called %d times for %d concurrent scrapes, want 1", n, scrapes)
+	}
+
+	// Once it's done, the next scrape refreshes afresh.
+	collectAll(z)
+	if n := atomic.LoadInt32(&fb.opens); n != 2 {
+		t.Errorf("OpenPools called %d times after a further scrape, want 2", n)
+	}
+}
+
+// TestDiscoveryInterval checks that pools aren't rediscovered within
+// -zfs.discovery-interval, however many scrapes come in.
+func TestDiscoveryInterval(t *testing.T) {
+	fb := &fakeBackend{pools: []*fakePool{healthyPool()}}
+	z := NewZfsCollector(fb, CollectorOpts{
+		DiscoveryInterval: time.Hour,
+		PoolTimeout:       time.Second,
+		Collectors:        enabledCollectors(),
+	})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collectAll(z)
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&fb.opens); n != 1 {
+		t.Errorf("OpenPools called %d times within the discovery interval, want 1 (from Init)", n)
+	}
+}
+
+// TestHungPoolDoesNotBlockScrapes checks that a pool stuck in the kernel is
+// left pending after it times out, instead of being collected again and
+// holding up every later scrape.
+func TestHungPoolDoesNotBlockScrapes(t *testing.T) {
+	hung := healthyPool()
+	hung.name = "hung"
+	hung.block = make(chan struct{})
+	defer close(hung.block)
+
+	timeout := 50 * time.Millisecond
+	z := NewZfsCollector(&fakeBackend{pools: []*fakePool{healthyPool(), hung}}, CollectorOpts{
+		PoolTimeout: timeout,
+		Collectors:  enabledCollectors(),
+	})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collectAll(z)
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * timeout):
+			t.Fatalf("scrape %d blocked on the hung pool", i)
+		}
+	}
+
+	if n := atomic.LoadInt32(&hung.refreshes); n != 1 {
+		t.Errorf("hung pool refreshed %d times, want 1", n)
+	}
+	z.refreshMu.Lock()
+	_, pending := z.pending[hung]
+	z.refreshMu.Unlock()
+	if !pending {
+		t.Error("hung pool not pending")
+	}
+}
+
+// TestVanishedPoolForgotten checks that when a pool goes away, e.g. because
+// it was exported, its handle is closed and everything about it is dropped,
+// so none of its series are reported any more.
+func TestVanishedPoolForgotten(t *testing.T) {
+	tank, backup := healthyPool(), healthyPool()
+	backup.name = "backup"
+	fb := &fakeBackend{pools: []*fakePool{tank, backup}}
+	z := NewZfsCollector(fb, CollectorOpts{PoolTimeout: time.Second, Collectors: allCollectors()})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if out := string(scrape(t, z)); !strings.Contains(out, `poolname="backup"`) {
+		t.Fatalf("no series for backup:\n%s", out)
+	}
+
+	// Rediscovery closes the duplicate handle OpenPools returns for a pool
+	// already known, which here is the same fakePool, so only count the
+	// closes from now on.
+	closes := backup.closes
+	fb.pools = []*fakePool{tank}
+	out := string(scrape(t, z))
+	if n := backup.closes - closes; n != 1 {
+		t.Errorf("handle of the vanished pool closed %d times, want 1", n)
+	}
+	if strings.Contains(out, `poolname="backup"`) {
+		t.Errorf("vanished pool still reported:\n%s", out)
+	}
+	if !strings.Contains(out, `poolname="tank"`) {
+		t.Errorf("remaining pool no longer reported:\n%s", out)
+	}
+	z.refreshMu.Lock()
+	_, errs := z.poolerrs["backup"]
+	_, success := z.lastSuccess["backup"]
+	npools := len(z.pools)
+	z.refreshMu.Unlock()
+	if errs || success {
+		t.Errorf("vanished pool still has error count %v, last success %v", errs, success)
+	}
+	if npools != 1 {
+		t.Errorf("got %d pools, want 1", npools)
+	}
+}
+
+// TestRecreatedPoolReplacesHandle checks that a pool replaced by another of
+// the same name between discoveries, e.g. destroyed and recreated, gets the
+// new pool's handle, and the old handle is closed.
+func TestRecreatedPoolReplacesHandle(t *testing.T) {
+	old := healthyPool()
+	fb := &fakeBackend{pools: []*fakePool{old}}
+	z := NewZfsCollector(fb, CollectorOpts{PoolTimeout: time.Second, Collectors: enabledCollectors()})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	recreated := healthyPool()
+	recreated.props[zfs.PoolPropGUID] = "11"
+	fb.pools = []*fakePool{recreated}
+	closes := old.closes
+	collectAll(z)
+	if n := old.closes - closes; n != 1 {
+		t.Errorf("handle of the replaced pool closed %d times, want 1", n)
+	}
+	z.refreshMu.Lock()
+	pools := z.pools
+	z.refreshMu.Unlock()
+	if len(pools) != 1 || pools[0] != PoolHandle(recreated) {
+		t.Errorf("got pools %v, want only the recreated one", pools)
+	}
+	if recreated.closes != 0 {
+		t.Errorf("handle of the recreated pool closed %d times", recreated.closes)
+	}
+}
+
+// TestPollInterval checks that with a poll interval the pools are refreshed
+// in the background, and that the last refresh and refresh age series follow
+// the most recent successful refresh, and go stale when refreshes stop.
+func TestPollInterval(t *testing.T) {
+	hung := healthyPool()
+	hung.name = "hung"
+	hung.block = make(chan struct{})
+	defer close(hung.block)
+
+	interval := 20 * time.Millisecond
+	fb := &fakeBackend{pools: []*fakePool{healthyPool(), hung}}
+	z := NewZfsCollector(fb, CollectorOpts{
+		PoolTimeout:  interval,
+		PollInterval: interval,
+		Collectors:   enabledCollectors(),
+	})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	z.Start()
+
+	// Start refreshes before returning, so the first scrape has data.  The
+	// hung pool has never been collected, so has no last refresh.
+	last, _ := refreshTimes(t, z)
+	if _, ok := last["tank"]; !ok {
+		t.Fatal("no last refresh for tank after Start")
+	}
+	if _, ok := last["hung"]; ok {
+		t.Error("last refresh reported for a pool never collected")
+	}
+
+	// Without any scrapes, the pools keep being refreshed.
+	opens := atomic.LoadInt32(&fb.opens)
+	time.Sleep(10 * interval)
+	if n := atomic.LoadInt32(&fb.opens) - opens; n < 2 {
+		t.Errorf("pools refreshed %d times in %v, want several", n, 10*interval)
+	}
+	polled, _ := refreshTimes(t, z)
+	if polled["tank"] <= last["tank"] {
+		t.Errorf("last refresh didn't advance from %v", last["tank"])
+	}
+
+	// While refreshes are held up, scrapes still get the last snapshot,
+	// which ages.
+	z.refreshMu.Lock()
+	stuck, _ := refreshTimes(t, z)
+	time.Sleep(10 * interval)
+	later, age := refreshTimes(t, z)
+	z.refreshMu.Unlock()
+	if later["tank"] != stuck["tank"] {
+		t.Errorf("last refresh changed from %v to %v with refreshes held up", stuck["tank"], later["tank"])
+	}
+	if age["tank"] < (10 * interval).Seconds() {
+		t.Errorf("refresh age %vs, want at least %v", age["tank"], 10*interval)
+	}
+}
+
+// refreshTimes scrapes z and returns the last refresh timestamp and refresh
+// age of each pool, by pool name.
+func refreshTimes(t *testing.T, z *ZfsCollector) (last, age map[string]float64) {
+	r := prometheus.NewPedanticRegistry()
+	if err := r.Register(z); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, age = map[string]float64{}, map[string]float64{}
+	for _, mf := range mfs {
+		var values map[string]float64
+		switch mf.GetName() {
+		case "zfs_zpool_last_refresh_timestamp_seconds":
+			values = last
+		case "zfs_zpool_refresh_age_seconds":
+			values = age
+		default:
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "poolname" {
+					values[lp.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return last, age
+}
+
+// collectAll runs z.Collect and discards the metrics.
+func collectAll(z *ZfsCollector) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		z.Collect(ch)
+		close(ch)
+	}()
+	for range ch {
+	}
+}
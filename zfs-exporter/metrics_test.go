@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.golden from the current output")
+
+// volatileMetrics depend on when the scrape happens, so they're left out of
+// the golden files.
+var volatileMetrics = map[string]bool{
+	"zfs_exporter_collector_duration_seconds":               true,
+	"zfs_zpool_last_refresh_timestamp_seconds":              true,
+	"zfs_zpool_refresh_age_seconds":                         true,
+	"zfs_zpool_scan_rate_bytes_per_second":                  true,
+	"zfs_zpool_scan_estimated_completion_timestamp_seconds": true,
+}
+
+// goldenScenarios build the pool each golden file describes.
+var goldenScenarios = map[string]func() *fakePool{
+	"healthy":     healthyPool,
+	"degraded":    degradedPool,
+	"faulted":     faultedPool,
+	"resilvering": resilveringPool,
+	"roles":       rolesPool,
+}
+
+// TestMetricsGolden scrapes each scenario through a registry, as /metrics
+// would, and compares the output with testdata/<scenario>.golden.  Run with
+// -update to regenerate the golden files after an intended change.
+func TestMetricsGolden(t *testing.T) {
+	for name, newPool := range goldenScenarios {
+		z := NewZfsCollector(&fakeBackend{pools: []*fakePool{newPool()}}, CollectorOpts{
+			PoolTimeout: 5 * time.Second,
+			Collectors:  allCollectors(),
+		})
+		if err := z.Init(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := scrape(t, z)
+
+		path := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v (run go test -update to create it)", name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: output differs from %s:\n%s", name, path, firstDiff(string(want), string(got)))
+		}
+	}
+}
+
+// allCollectors instantiates every registered sub-collector, including those
+// disabled by default.
+func allCollectors() map[string]subCollector {
+	collectors := make(map[string]subCollector)
+	for name, def := range collectorDefs {
+		if def.factory != nil {
+			collectors[name] = def.factory()
+		}
+	}
+	return collectors
+}
+
+// scrape gathers the metrics of z in the text exposition format, minus the
+// volatile ones.
+func scrape(t *testing.T, z *ZfsCollector) []byte {
+	r := prometheus.NewPedanticRegistry()
+	if err := r.Register(z); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, mf := range mfs {
+		if volatileMetrics[mf.GetName()] {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// scrapeWith scrapes the single pool p with only the given collectors, and
+// returns the non-volatile metrics in the text exposition format.
+func scrapeWith(t *testing.T, p *fakePool, collectors map[string]subCollector) string {
+	z := NewZfsCollector(&fakeBackend{pools: []*fakePool{p}}, CollectorOpts{
+		PoolTimeout: 5 * time.Second,
+		Collectors:  collectors,
+	})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return string(scrape(t, z))
+}
+
+// TestDatasetQuotas checks the quota and reservation series of a dataset with
+// a quota and a refquota set, and that unset limits are left out.
+func TestDatasetQuotas(t *testing.T) {
+	p := healthyPool()
+	p.root.children = append(p.root.children, &fakeDataset{
+		typ: zfs.DatasetTypeFilesystem,
+		props: map[zfs.Prop]string{
+			zfs.DatasetPropName:           "tank/home",
+			zfs.DatasetPropUsed:           "1610612736",
+			zfs.DatasetPropReferenced:     "805306368",
+			zfs.DatasetPropQuota:          "2147483648",
+			zfs.DatasetPropRefquota:       "1073741824",
+			zfs.DatasetPropReservation:    "0",
+			zfs.DatasetPropRefreservation: "0",
+		},
+	})
+	out := scrapeWith(t, p, map[string]subCollector{"dataset": datasetCollector{}})
+
+	const labels = `{dataset="tank/home",poolname="tank",type="filesystem"}`
+	for _, want := range []string{
+		"zfs_dataset_quota_bytes" + labels + " 2.147483648e+09",
+		"zfs_dataset_quota_utilization_ratio" + labels + " 0.75",
+		"zfs_dataset_refquota_bytes" + labels + " 1.073741824e+09",
+		"zfs_dataset_refquota_utilization_ratio" + labels + " 0.75",
+		// The refquota, with 256MiB left, is tighter than the quota.
+		"zfs_dataset_bytes_until_quota" + labels + " 2.68435456e+08",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, unwanted := range []string{
+		"zfs_dataset_reservation_bytes{",
+		"zfs_dataset_refreservation_bytes{",
+		`zfs_dataset_quota_bytes{dataset="tank",`,
+		`zfs_dataset_bytes_until_quota{dataset="tank",`,
+	} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %s series:\n%s", unwanted, out)
+		}
+	}
+}
+
+// TestDatasetUserProperties checks that the configured user properties are
+// exported where they're set, and only there.
+func TestDatasetUserProperties(t *testing.T) {
+	p := healthyPool()
+	p.root.namedProps = map[string]string{"com.acme:owner": "ops"}
+	p.root.children = append(p.root.children, &fakeDataset{
+		typ:   zfs.DatasetTypeFilesystem,
+		props: map[zfs.Prop]string{zfs.DatasetPropName: "tank/home"},
+		namedProps: map[string]string{
+			"com.acme:owner":  "alice",
+			"com.acme:tenant": "acme",
+			"com.acme:other":  "not exported",
+		},
+	})
+	out := scrapeWith(t, p, map[string]subCollector{
+		"dataset": datasetCollector{userProps: []string{"com.acme:owner", "com.acme:tenant"}},
+	})
+
+	var got []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "zfs_dataset_user_property_info{") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		`zfs_dataset_user_property_info{dataset="tank",poolname="tank",property="com.acme:owner",value="ops"} 1`,
+		`zfs_dataset_user_property_info{dataset="tank/home",poolname="tank",property="com.acme:owner",value="alice"} 1`,
+		`zfs_dataset_user_property_info{dataset="tank/home",poolname="tank",property="com.acme:tenant",value="acme"} 1`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestSnapshotPrefixes checks that snapshots are grouped by the first
+// configured prefix their name starts with, those matching none going under
+// the empty prefix, and that a prefix no snapshot has only gets a count.
+func TestSnapshotPrefixes(t *testing.T) {
+	p := healthyPool()
+	snap := func(name, creation, used string) *fakeDataset {
+		return &fakeDataset{
+			typ: zfs.DatasetTypeSnapshot,
+			props: map[zfs.Prop]string{
+				zfs.DatasetPropName:     "tank@" + name,
+				zfs.DatasetPropCreation: creation,
+				zfs.DatasetPropUsed:     used,
+			},
+		}
+	}
+	p.root.children = []*fakeDataset{
+		snap("autosnap_2017-07-14", "1500000000", "100"),
+		snap("autosnap_2017-07-15", "1500086400", "200"),
+		snap("manual-upgrade", "1500001000", "50"),
+		// Matches autosnap_ too, but manual- comes first.
+		snap("manual-autosnap_", "1500002000", "5"),
+		snap("before-upgrade", "1499990000", "10"),
+	}
+	out := scrapeWith(t, p, map[string]subCollector{
+		"snapshot": snapshotCollector{prefixes: []string{"manual-", "autosnap_", "daily_"}},
+	})
+
+	for _, want := range []string{
+		`zfs_dataset_snapshots{dataset="tank",poolname="tank",prefix="autosnap_"} 2`,
+		`zfs_dataset_snapshot_newest_creation_timestamp_seconds{dataset="tank",poolname="tank",prefix="autosnap_"} 1.5000864e+09`,
+		`zfs_dataset_snapshot_oldest_creation_timestamp_seconds{dataset="tank",poolname="tank",prefix="autosnap_"} 1.5e+09`,
+		`zfs_dataset_snapshot_used_bytes{dataset="tank",poolname="tank",prefix="autosnap_"} 300`,
+		`zfs_dataset_snapshots{dataset="tank",poolname="tank",prefix="manual-"} 2`,
+		`zfs_dataset_snapshot_used_bytes{dataset="tank",poolname="tank",prefix="manual-"} 55`,
+		`zfs_dataset_snapshots{dataset="tank",poolname="tank",prefix=""} 1`,
+		`zfs_dataset_snapshot_oldest_creation_timestamp_seconds{dataset="tank",poolname="tank",prefix=""} 1.49999e+09`,
+		`zfs_dataset_snapshots{dataset="tank",poolname="tank",prefix="daily_"} 0`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if unwanted := `zfs_dataset_snapshot_newest_creation_timestamp_seconds{dataset="tank",poolname="tank",prefix="daily_"}`; strings.Contains(out, unwanted) {
+		t.Errorf("unexpected %s", unwanted)
+	}
+}
+
+// firstDiff describes the first line at which want and got differ.
+func firstDiff(want, got string) string {
+	wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\nwant: %s\ngot:  %s", i+1, w, g)
+		}
+	}
+	return "no differing line"
+}
+
+// healthyPool returns pool tank, a two-way mirror of sda and sdb in good
+// health, with a handful of properties, features and datasets.  The other
+// scenarios start from it.
+func healthyPool() *fakePool {
+	disk := func(name string, id, guid uint64) zfs.VDevTree {
+		return zfs.VDevTree{
+			Type: zfs.VDevTypeDisk, Name: name, Id: id, GUID: guid,
+			Path: "/dev/" + name + "1", DevID: "ata-DISK-" + name, PhysPath: "pci-0000:00:1f.2-" + name,
+			Stat: zfs.VDevStat{
+				State: zfs.VDevStateHealthy,
+				Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40, RSize: 1<<40 + 1<<20,
+				Ops:   [zfs.ZIOTypes]uint64{0, 1000, 2000, 30, 0, 5},
+				Bytes: [zfs.ZIOTypes]uint64{0, 1 << 24, 1 << 25, 0, 0, 0},
+			},
+		}
+	}
+	mirror := zfs.VDevTree{
+		Type: zfs.VDevTypeMirror, Name: "mirror-0", GUID: 20,
+		Devices: []zfs.VDevTree{disk("sda", 0, 21), disk("sdb", 1, 22)},
+		Stat:    zfs.VDevStat{State: zfs.VDevStateHealthy, Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40},
+	}
+	return &fakePool{
+		name:   "tank",
+		state:  zfs.PoolStateActive,
+		status: zfs.PoolStatusOk,
+		vdevs: zfs.VDevTree{
+			Type: zfs.VDevTypeRoot, Name: "tank", GUID: 10,
+			Devices: []zfs.VDevTree{mirror},
+			Stat:    zfs.VDevStat{State: zfs.VDevStateHealthy, Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40},
+			ScanStat: zfs.PoolScanStat{
+				Func: zfs.PoolScanScrub, State: zfs.DSSFinished,
+				StartTime: 1500000000, EndTime: 1500003600,
+				ToExamine: 1 << 30, Examined: 1 << 30,
+			},
+		},
+		props: map[zfs.Prop]string{
+			zfs.PoolPropName:         "tank",
+			zfs.PoolPropHealth:       "ONLINE",
+			zfs.PoolPropGUID:         "10",
+			zfs.PoolPropVersion:      "-",
+			zfs.PoolPropSize:         "1099511627776",
+			zfs.PoolPropAllocated:    "1073741824",
+			zfs.PoolPropFree:         "1098437885952",
+			zfs.PoolPropFreeing:      "0",
+			zfs.PoolPropLeaked:       "0",
+			zfs.PoolPropExpandsz:     "-",
+			zfs.PoolPropCapacity:     "0",
+			zfs.PoolPropFragmentaion: "1",
+			zfs.PoolPropDedupratio:   "1.00",
+			zfs.PoolPropAshift:       "12",
+			zfs.PoolPropMaxBlockSize: "1048576",
+			zfs.PoolPropAutoexpand:   "off",
+			zfs.PoolPropAutoreplace:  "off",
+			zfs.PoolPropFailuremode:  "wait",
+			zfs.PoolPropReadonly:     "off",
+			zfs.PoolPropAltroot:      "-",
+		},
+		feats: map[string]string{
+			"async_destroy": "enabled",
+			"large_blocks":  "disabled",
+			"lz4_compress":  "active",
+		},
+		root: &fakeDataset{
+			typ: zfs.DatasetTypeFilesystem,
+			props: map[zfs.Prop]string{
+				zfs.DatasetPropName:          "tank",
+				zfs.DatasetPropUsed:          "1073741824",
+				zfs.DatasetPropAvailable:     "1063004405760",
+				zfs.DatasetPropReferenced:    "98304",
+				zfs.DatasetPropCompressratio: "1.50",
+				zfs.DatasetPropCompression:   "lz4",
+				zfs.DatasetPropRecordsize:    "131072",
+				zfs.DatasetPropSync:          "standard",
+				zfs.DatasetPropDedup:         "off",
+				zfs.DatasetPropAtime:         "on",
+				zfs.DatasetPropCanmount:      "on",
+				zfs.DatasetPropMountpoint:    "/tank",
+			},
+			mounted: true,
+			children: []*fakeDataset{
+				{
+					typ: zfs.DatasetTypeSnapshot,
+					props: map[zfs.Prop]string{
+						zfs.DatasetPropName:     "tank@auto_1",
+						zfs.DatasetPropUsed:     "65536",
+						zfs.DatasetPropCreation: "1500000000",
+					},
+				},
+			},
+		},
+	}
+}
+
+// degradedPool is healthyPool with sdb unplugged.
+func degradedPool() *fakePool {
+	p := healthyPool()
+	p.status = zfs.PoolStatusMissingDevR
+	p.props[zfs.PoolPropHealth] = "DEGRADED"
+	p.vdevs.Stat.State = zfs.VDevStateDegraded
+	mirror := &p.vdevs.Devices[0]
+	mirror.Stat.State = zfs.VDevStateDegraded
+	sdb := &mirror.Devices[1]
+	sdb.Stat.State = zfs.VDevStateCantOpen
+	sdb.Stat.Aux = zfs.VDevAuxOpenFailed
+	return p
+}
+
+// faultedPool is healthyPool with both disks faulted for too many errors.
+func faultedPool() *fakePool {
+	p := healthyPool()
+	p.status = zfs.PoolStatusFaultedDevNr
+	p.props[zfs.PoolPropHealth] = "UNAVAIL"
+	p.vdevs.Stat.State = zfs.VDevStateCantOpen
+	p.vdevs.Stat.Aux = zfs.VDevAuxNoReplicas
+	mirror := &p.vdevs.Devices[0]
+	mirror.Stat.State = zfs.VDevStateCantOpen
+	mirror.Stat.Aux = zfs.VDevAuxNoReplicas
+	for i := range mirror.Devices {
+		d := &mirror.Devices[i]
+		d.Stat.State = zfs.VDevStateFaulted
+		d.Stat.Aux = zfs.VDevAuxErrExceeded
+		d.Stat.ReadErrors = 12 + uint64(i)
+		d.Stat.ChecksumErrors = 3
+	}
+	return p
+}
+
+// resilveringPool is healthyPool halfway through replacing sdb with sdc.
+func resilveringPool() *fakePool {
+	p := healthyPool()
+	p.status = zfs.PoolStatusResilvering
+	p.props[zfs.PoolPropHealth] = "DEGRADED"
+	p.vdevs.Stat.State = zfs.VDevStateDegraded
+	mirror := &p.vdevs.Devices[0]
+	mirror.Stat.State = zfs.VDevStateDegraded
+
+	sdb := mirror.Devices[1]
+	sdb.Stat.State = zfs.VDevStateCantOpen
+	sdb.Stat.Aux = zfs.VDevAuxOpenFailed
+	sdc := zfs.VDevTree{
+		Type: zfs.VDevTypeDisk, Name: "sdc", Id: 1, GUID: 24,
+		Path: "/dev/sdc1", DevID: "ata-DISK-sdc", PhysPath: "pci-0000:00:1f.2-sdc",
+		Stat: zfs.VDevStat{
+			State: zfs.VDevStateHealthy,
+			Alloc: 1 << 29, Space: 1 << 40, DSpace: 1 << 40, RSize: 1<<40 + 1<<20,
+			ScanProcessed: 1 << 29,
+		},
+	}
+	sdb.Id = 0
+	mirror.Devices[1] = zfs.VDevTree{
+		Type: zfs.VDevTypeReplacing, Name: "replacing-1", Id: 1, GUID: 23,
+		Devices: []zfs.VDevTree{sdb, sdc},
+		Stat:    zfs.VDevStat{State: zfs.VDevStateDegraded, Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40},
+	}
+
+	p.vdevs.ScanStat = zfs.PoolScanStat{
+		Func: zfs.PoolScanResilver, State: zfs.DSSScanning,
+		StartTime: 1500100000, ToExamine: 1 << 30, Examined: 1 << 29, Processed: 1 << 29,
+		PassStart: 1500100000, PassExam: 1 << 29,
+	}
+	return p
+}
+
+// rolesPool is healthyPool with a mirrored log, a special vdev, an L2ARC
+// device and two hot spares, one of which, sdf, has stood in for a faulted
+// sdb.
+func rolesPool() *fakePool {
+	p := healthyPool()
+	p.status = zfs.PoolStatusFaultedDevR
+	p.props[zfs.PoolPropHealth] = "DEGRADED"
+	p.vdevs.Stat.State = zfs.VDevStateDegraded
+	p.vdevs.ScanStat = zfs.PoolScanStat{
+		Func: zfs.PoolScanResilver, State: zfs.DSSFinished,
+		StartTime: 1500100000, EndTime: 1500101800,
+		ToExamine: 1 << 30, Examined: 1 << 30, Processed: 1 << 30,
+	}
+
+	sda := p.vdevs.Devices[0].Devices[0]
+	leaf := func(name string, id, guid uint64) zfs.VDevTree {
+		d := sda
+		d.Name, d.Id, d.GUID = name, id, guid
+		d.Path, d.DevID, d.PhysPath = "/dev/"+name+"1", "ata-DISK-"+name, "pci-0000:00:1f.2-"+name
+		return d
+	}
+	mirror := func(name string, id, guid uint64, devices ...zfs.VDevTree) zfs.VDevTree {
+		return zfs.VDevTree{
+			Type: zfs.VDevTypeMirror, Name: name, Id: id, GUID: guid, Devices: devices,
+			Stat: zfs.VDevStat{State: zfs.VDevStateHealthy, Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40},
+		}
+	}
+
+	data := &p.vdevs.Devices[0]
+	data.Stat.State = zfs.VDevStateDegraded
+	sdb := data.Devices[1]
+	sdb.Id = 0
+	sdb.Stat.State = zfs.VDevStateFaulted
+	sdb.Stat.Aux = zfs.VDevAuxErrExceeded
+	sdb.Stat.ReadErrors = 40
+	sdf := leaf("sdf", 1, 51)
+	sdf.Stat.ScanProcessed = 1 << 30
+	data.Devices[1] = zfs.VDevTree{
+		Type: zfs.VDevTypeSpare, Name: "spare-1", Id: 1, GUID: 23,
+		Devices: []zfs.VDevTree{sdb, sdf},
+		Stat:    zfs.VDevStat{State: zfs.VDevStateDegraded, Alloc: 1 << 30, Space: 1 << 40, DSpace: 1 << 40},
+	}
+
+	special := mirror("mirror-2", 2, 40, leaf("nvme2n1", 0, 41), leaf("nvme3n1", 1, 42))
+	special.AllocBias = "special"
+	p.vdevs.Devices = append(p.vdevs.Devices, special)
+	p.vdevs.Logs = []zfs.VDevTree{mirror("mirror-1", 1, 30, leaf("sdd", 0, 31), leaf("sde", 1, 32))}
+
+	// Spares and cache devices have no id.  An in-use spare is reported
+	// as spared both here and, healthy, under the vdev it stands in for.
+	inUse := leaf("sdf", 0, 51)
+	inUse.Stat.Aux = zfs.VDevAuxSpared
+	p.vdevs.Spares = []zfs.VDevTree{inUse, leaf("sdg", 0, 52)}
+	p.vdevs.L2Cache = []zfs.VDevTree{leaf("nvme0n1", 0, 60)}
+	return p
+}
@@ -0,0 +1,123 @@
+package nvlist
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixtureDir is where testdata/mkcache.sh puts the files backing the pools.
+const fixtureDir = "/var/tmp/zfs-exporter-fixtures/"
+
+// cacheVdev describes a vdev expected in the cache file fixtures.
+type cacheVdev struct {
+	typ      string
+	path     string
+	children []cacheVdev
+}
+
+// cachePools are the pools testdata/mkcache.sh creates, by name, with their
+// top-level vdevs.
+var cachePools = map[string][]cacheVdev{
+	"zfsexp_tank": {
+		{typ: "mirror", children: []cacheVdev{
+			{typ: "file", path: fixtureDir + "a"},
+			{typ: "file", path: fixtureDir + "b"},
+		}},
+		{typ: "raidz", children: []cacheVdev{
+			{typ: "file", path: fixtureDir + "c"},
+			{typ: "file", path: fixtureDir + "d"},
+			{typ: "file", path: fixtureDir + "e"},
+		}},
+	},
+	"zfsexp_backup": {
+		{typ: "file", path: fixtureDir + "h"},
+	},
+}
+
+// TestUnpackCachefile unpacks the zpool.cache written by ZFS for the pools in
+// cachePools, in XDR and repacked natively by libnvpair, checks that they
+// hold those pools, and that Pack reproduces the files exactly.  The files
+// are made by testdata/mkcache.sh.
+func TestUnpackCachefile(t *testing.T) {
+	var unpacked []*List
+	for _, fixture := range []struct {
+		enc  Encoding
+		path string
+	}{
+		{XDR, filepath.Join("testdata", "mirror-raidz.xdr.cache")},
+		{Native, filepath.Join("testdata", "mirror-raidz.native.cache")},
+	} {
+		path := fixture.path
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			t.Skipf("%s missing; run testdata/mkcache.sh on a host with ZFS to make it", path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := Unpack(data)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		unpacked = append(unpacked, l)
+
+		if len(l.Pairs) != len(cachePools) {
+			t.Errorf("%s: got %d pools, want %d", path, len(l.Pairs), len(cachePools))
+		}
+		for name, vdevs := range cachePools {
+			config, ok := l.Nvlist(name)
+			if !ok {
+				t.Errorf("%s: no pool %s", path, name)
+				continue
+			}
+			if got, _ := config.String("name"); got != name {
+				t.Errorf("%s: pool %s has name %q", path, name, got)
+			}
+			tree, ok := config.Nvlist("vdev_tree")
+			if !ok {
+				t.Errorf("%s: pool %s has no vdev_tree", path, name)
+				continue
+			}
+			// The root vdev's guid is the pool's.
+			guid, _ := config.Uint64("pool_guid")
+			if rootGUID, _ := tree.Uint64("guid"); guid == 0 || rootGUID != guid {
+				t.Errorf("%s: pool %s has guid %d, root vdev guid %d", path, name, guid, rootGUID)
+			}
+			checkVdevs(t, path+": "+name, tree, vdevs)
+		}
+
+		repacked, err := Pack(l, fixture.enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repacked, data) {
+			t.Errorf("%s: Pack doesn't reproduce it", path)
+		}
+	}
+	if !reflect.DeepEqual(unpacked[0], unpacked[1]) {
+		t.Error("XDR and native fixtures unpack differently")
+	}
+}
+
+// checkVdevs checks the types and paths of the children of parent, and
+// recursively theirs.
+func checkVdevs(t *testing.T, where string, parent *List, want []cacheVdev) {
+	p, _ := parent.Lookup("children")
+	got, _ := p.Value.([]*List)
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d children, want %d", where, len(got), len(want))
+		return
+	}
+	for i, w := range want {
+		typ, _ := got[i].String("type")
+		path, _ := got[i].String("path")
+		if typ != w.typ || path != w.path {
+			t.Errorf("%s: child %d is %s %q, want %s %q", where, i, typ, path, w.typ, w.path)
+		}
+		checkVdevs(t, where, got[i], w.children)
+	}
+}
@@ -0,0 +1,384 @@
+package nvlist
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// kindType is the Type values of a Go kind are marshalled as, alone and in
+// slices, along with the Go type of the resulting Pair's value.
+type kindType struct {
+	scalar, array Type
+	goType        reflect.Type
+}
+
+var (
+	listType = reflect.TypeOf(List{})
+
+	kindTypes = map[reflect.Kind]kindType{
+		reflect.Bool:    {TypeBooleanValue, TypeBooleanArray, reflect.TypeOf(false)},
+		reflect.Int8:    {TypeInt8, TypeInt8Array, reflect.TypeOf(int8(0))},
+		reflect.Int16:   {TypeInt16, TypeInt16Array, reflect.TypeOf(int16(0))},
+		reflect.Int32:   {TypeInt32, TypeInt32Array, reflect.TypeOf(int32(0))},
+		reflect.Int:     {TypeInt64, TypeInt64Array, reflect.TypeOf(int64(0))},
+		reflect.Int64:   {TypeInt64, TypeInt64Array, reflect.TypeOf(int64(0))},
+		reflect.Uint8:   {TypeUint8, TypeByteArray, reflect.TypeOf(uint8(0))},
+		reflect.Uint16:  {TypeUint16, TypeUint16Array, reflect.TypeOf(uint16(0))},
+		reflect.Uint32:  {TypeUint32, TypeUint32Array, reflect.TypeOf(uint32(0))},
+		reflect.Uint:    {TypeUint64, TypeUint64Array, reflect.TypeOf(uint64(0))},
+		reflect.Uint64:  {TypeUint64, TypeUint64Array, reflect.TypeOf(uint64(0))},
+		reflect.Uintptr: {TypeUint64, TypeUint64Array, reflect.TypeOf(uint64(0))},
+		reflect.Float32: {TypeDouble, TypeUnknown, reflect.TypeOf(float64(0))},
+		reflect.Float64: {TypeDouble, TypeUnknown, reflect.TypeOf(float64(0))},
+		reflect.String:  {TypeString, TypeStringArray, reflect.TypeOf("")},
+	}
+)
+
+// Unmarshal stores the pairs of l in the value v points to, which may be a
+// struct, a map with string keys, or an empty interface, which gets l.Map().
+//
+// A pair is stored in the struct field whose nvlist tag is the pair's name,
+// or failing that whose name matches it ignoring case; a tag of "-" skips the
+// field.  Pairs with no field are ignored.  Numbers are stored in fields of
+// any numeric kind they fit, nested nvlists in structs or maps, and arrays in
+// slices.
+func Unmarshal(l *List, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("nvlist: Unmarshal needs a non-nil pointer")
+	}
+	return unmarshalList(l, rv.Elem())
+}
+
+func unmarshalList(l *List, dst reflect.Value) error {
+	if l == nil {
+		return errors.New("nvlist: can't unmarshal nil nvlist")
+	}
+	if dst.Type() == listType {
+		dst.Set(reflect.ValueOf(*l))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return unmarshalList(l, dst.Elem())
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			dst.Set(reflect.ValueOf(l.Map()))
+			return nil
+		}
+	case reflect.Map:
+		t := dst.Type()
+		if t.Key().Kind() != reflect.String {
+			break
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(t))
+		}
+		for _, p := range l.Pairs {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := unmarshalPair(p, elem); err != nil {
+				return err
+			}
+			dst.SetMapIndex(reflect.ValueOf(p.Name).Convert(t.Key()), elem)
+		}
+		return nil
+	case reflect.Struct:
+		for _, p := range l.Pairs {
+			i, ok := fieldFor(dst.Type(), p.Name)
+			if !ok {
+				continue
+			}
+			if err := unmarshalPair(p, dst.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("nvlist: can't unmarshal nvlist into %v", dst.Type())
+}
+
+func unmarshalPair(p Pair, dst reflect.Value) error {
+	switch v := p.Value.(type) {
+	case *List:
+		return unmarshalList(v, dst)
+	case []*List:
+		if dst.Kind() == reflect.Slice {
+			s := reflect.MakeSlice(dst.Type(), len(v), len(v))
+			for i, l := range v {
+				if err := unmarshalList(l, s.Index(i)); err != nil {
+					return err
+				}
+			}
+			dst.Set(s)
+			return nil
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return unmarshalPair(p, dst.Elem())
+	case reflect.Interface:
+		if dst.NumMethod() == 0 {
+			m := (&List{Pairs: []Pair{p}}).Map()
+			dst.Set(reflect.ValueOf(m[p.Name]))
+			return nil
+		}
+	}
+
+	src := reflect.ValueOf(p.Value)
+	if src.Kind() == reflect.Slice && dst.Kind() == reflect.Slice {
+		s := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := convertValue(src.Index(i), s.Index(i)); err != nil {
+				return fmt.Errorf("nvlist: pair %q: %v", p.Name, err)
+			}
+		}
+		dst.Set(s)
+		return nil
+	}
+	if err := convertValue(src, dst); err != nil {
+		return fmt.Errorf("nvlist: pair %q: %v", p.Name, err)
+	}
+	return nil
+}
+
+// convertValue stores the scalar src in dst, converting between numeric
+// kinds where the value fits.
+func convertValue(src, dst reflect.Value) error {
+	if !src.IsValid() {
+		return fmt.Errorf("can't store nil in %v", dst.Type())
+	}
+	switch dst.Kind() {
+	case reflect.Bool:
+		if src.Kind() == reflect.Bool {
+			dst.SetBool(src.Bool())
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = src.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if src.Uint() > math.MaxInt64 {
+				return fmt.Errorf("%d overflows %v", src.Uint(), dst.Type())
+			}
+			n = int64(src.Uint())
+		default:
+			return fmt.Errorf("can't store %v in %v", src.Type(), dst.Type())
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %v", n, dst.Type())
+		}
+		dst.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 {
+				return fmt.Errorf("%d overflows %v", src.Int(), dst.Type())
+			}
+			n = uint64(src.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = src.Uint()
+		default:
+			return fmt.Errorf("can't store %v in %v", src.Type(), dst.Type())
+		}
+		if dst.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %v", n, dst.Type())
+		}
+		dst.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if src.Kind() == reflect.Float64 {
+			dst.SetFloat(src.Float())
+			return nil
+		}
+	case reflect.String:
+		if src.Kind() == reflect.String {
+			dst.SetString(src.String())
+			return nil
+		}
+	}
+	return fmt.Errorf("can't store %v in %v", src.Type(), dst.Type())
+}
+
+// fieldFor returns the index of the field of the struct type t that the
+// pair called name is stored in.
+func fieldFor(t reflect.Type, name string) (int, bool) {
+	folded := -1
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("nvlist")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if tag != "" {
+			if tag == name {
+				return i, true
+			}
+			continue
+		}
+		if f.Name == name {
+			return i, true
+		}
+		if folded < 0 && strings.EqualFold(f.Name, name) {
+			folded = i
+		}
+	}
+	return folded, folded >= 0
+}
+
+// Marshal converts v, a struct or a map with string keys, to a List.  It's
+// the inverse of Unmarshal: each exported field or map entry becomes a pair
+// named by its nvlist tag, field name or key.  Map entries are sorted by key.
+//
+// Booleans become boolean values, ints and uints 64 bit numbers and sized
+// numbers the same size, floats doubles, structs and maps nested nvlists,
+// and slices arrays.  Nil pointers, maps, slices and interfaces are left
+// out.
+func Marshal(v interface{}) (*List, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, errors.New("nvlist: can't marshal nil")
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil, errors.New("nvlist: can't marshal nil")
+	}
+	return marshalList(rv)
+}
+
+func marshalList(rv reflect.Value) (*List, error) {
+	if rv.Type() == listType {
+		l := rv.Interface().(List)
+		return &l, nil
+	}
+	l := &List{}
+	add := func(name string, v reflect.Value) error {
+		p, ok, err := marshalPair(name, v)
+		if ok {
+			l.Pairs = append(l.Pairs, p)
+		}
+		return err
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := rv.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("nvlist")
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if err := add(name, rv.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("nvlist: can't marshal %v", rv.Type())
+		}
+		keys := make(map[string]reflect.Value, rv.Len())
+		names := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys[k.String()] = k
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := add(name, rv.MapIndex(keys[name])); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("nvlist: can't marshal %v", rv.Type())
+	}
+	return l, nil
+}
+
+// marshalPair converts v to a pair called name, or returns false if it's
+// nil.
+func marshalPair(name string, v reflect.Value) (Pair, bool, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return Pair{}, false, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map:
+		if v.Kind() == reflect.Map && v.IsNil() {
+			return Pair{}, false, nil
+		}
+		l, err := marshalList(v)
+		if err != nil {
+			return Pair{}, false, err
+		}
+		return Pair{Name: name, Type: TypeNvlist, Value: l}, true, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return Pair{}, false, nil
+		}
+		return marshalArray(name, v)
+	}
+
+	kt, ok := kindTypes[v.Kind()]
+	if !ok {
+		return Pair{}, false, fmt.Errorf("nvlist: %q: can't marshal %v", name, v.Type())
+	}
+	return Pair{Name: name, Type: kt.scalar, Value: v.Convert(kt.goType).Interface()}, true, nil
+}
+
+func marshalArray(name string, v reflect.Value) (Pair, bool, error) {
+	et := v.Type().Elem()
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() == reflect.Struct || et.Kind() == reflect.Map {
+		lists := make([]*List, v.Len())
+		for i := range lists {
+			elem := v.Index(i)
+			for elem.Kind() == reflect.Ptr {
+				if elem.IsNil() {
+					return Pair{}, false, fmt.Errorf("nvlist: %q: can't marshal nil element", name)
+				}
+				elem = elem.Elem()
+			}
+			l, err := marshalList(elem)
+			if err != nil {
+				return Pair{}, false, err
+			}
+			lists[i] = l
+		}
+		return Pair{Name: name, Type: TypeNvlistArray, Value: lists}, true, nil
+	}
+
+	kt, ok := kindTypes[v.Type().Elem().Kind()]
+	if !ok || kt.array == TypeUnknown {
+		return Pair{}, false, fmt.Errorf("nvlist: %q: can't marshal %v", name, v.Type())
+	}
+	s := reflect.MakeSlice(reflect.SliceOf(kt.goType), v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		s.Index(i).Set(v.Index(i).Convert(kt.goType))
+	}
+	return Pair{Name: name, Type: kt.array, Value: s.Interface()}, true, nil
+}
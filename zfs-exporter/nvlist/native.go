@@ -0,0 +1,533 @@
+package nvlist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The native encoding is a copy of libnvpair's in-memory structures, as laid
+// out on an LP64 host with the byte order given in the header:
+//
+//	list: version int32, flags uint32, pair..., 0 int32
+//	pair: size int32, name size int16, reserved int16, nelem int32,
+//	      type int32, name with NUL, value
+//
+// The value starts at the next multiple of 8 after the name and the size of
+// the pair is rounded up to a multiple of 8.  Values take their natural size
+// except that boolean values are 4 bytes.  Pointers, in string arrays and
+// nvlist arrays, are zeroed: string arrays are followed by the strings, and
+// the contents of nested nvlists follow the pair as lists in their own right.
+const (
+	nativePairHeaderSize = 16
+	nativePointerSize    = 8
+	nativeNvlistSize     = 24 // sizeof(nvlist_t)
+)
+
+type nativeDecoder struct {
+	buf   []byte
+	off   int
+	order binary.ByteOrder
+}
+
+type nativeEncoder struct {
+	buf   []byte
+	order binary.ByteOrder
+}
+
+func (d *nativeDecoder) list(depth int) (*List, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	if len(d.buf)-d.off < 8 {
+		return nil, ErrTruncated
+	}
+	l := &List{
+		Version: int32(d.order.Uint32(d.buf[d.off:])),
+		Flags:   d.order.Uint32(d.buf[d.off+4:]),
+	}
+	d.off += 8
+
+	for {
+		if len(d.buf)-d.off < 4 {
+			return nil, ErrTruncated
+		}
+		size := int32(d.order.Uint32(d.buf[d.off:]))
+		if size == 0 {
+			d.off += 4
+			return l, nil
+		}
+		if size < nativePairHeaderSize {
+			return nil, fmt.Errorf("nvlist: bad pair size %d", size)
+		}
+		if int64(size) > int64(len(d.buf)-d.off) {
+			return nil, ErrTruncated
+		}
+		raw := d.buf[d.off : d.off+int(size)]
+		d.off += int(size)
+
+		p, err := d.pair(raw, depth)
+		if err != nil {
+			return nil, err
+		}
+		l.Pairs = append(l.Pairs, p)
+	}
+}
+
+// pair decodes the pair in raw.  Nested nvlists are read from the stream
+// that follows it.
+func (d *nativeDecoder) pair(raw []byte, depth int) (Pair, error) {
+	var p Pair
+	nameSize := int(int16(d.order.Uint16(raw[4:])))
+	nelem := int32(d.order.Uint32(raw[8:]))
+	p.Type = Type(int32(d.order.Uint32(raw[12:])))
+	if nameSize < 1 || nativePairHeaderSize+nameSize > len(raw) {
+		return p, fmt.Errorf("nvlist: bad pair name size %d", nameSize)
+	}
+	name := raw[nativePairHeaderSize : nativePairHeaderSize+nameSize]
+	if name[nameSize-1] != 0 {
+		return p, fmt.Errorf("nvlist: pair name not NUL-terminated")
+	}
+	p.Name = string(name[:nameSize-1])
+
+	valueOff := align8(nativePairHeaderSize + nameSize)
+	if valueOff > len(raw) {
+		return p, fmt.Errorf("nvlist: pair %q: bad size %d", p.Name, len(raw))
+	}
+	if nelem < 0 {
+		return p, fmt.Errorf("nvlist: pair %q: bad element count %d", p.Name, nelem)
+	}
+	var err error
+	p.Value, err = d.value(p.Type, raw[valueOff:], int(nelem), depth)
+	if err != nil {
+		return p, fmt.Errorf("nvlist: pair %q: %v", p.Name, err)
+	}
+	return p, nil
+}
+
+func (d *nativeDecoder) value(t Type, b []byte, nelem int, depth int) (interface{}, error) {
+	switch t {
+	case TypeBoolean:
+		return true, nil
+	case TypeString:
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return nil, fmt.Errorf("string not NUL-terminated")
+		}
+		return string(b[:i]), nil
+	case TypeStringArray:
+		if int64(nelem)*nativePointerSize > int64(len(b)) {
+			return nil, ErrTruncated
+		}
+		b = b[nelem*nativePointerSize:]
+		v := make([]string, nelem)
+		for i := range v {
+			j := bytes.IndexByte(b, 0)
+			if j < 0 {
+				return nil, fmt.Errorf("string not NUL-terminated")
+			}
+			v[i], b = string(b[:j]), b[j+1:]
+		}
+		return v, nil
+	case TypeNvlist:
+		return d.list(depth + 1)
+	case TypeNvlistArray:
+		// The pair holds the pointers and nvlist_ts, which bounds nelem by
+		// the input size before anything is allocated.
+		if int64(nelem)*(nativePointerSize+nativeNvlistSize) > int64(len(b)) {
+			return nil, ErrTruncated
+		}
+		v := make([]*List, nelem)
+		for i := range v {
+			l, err := d.list(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = l
+		}
+		return v, nil
+	}
+
+	size := nativeElemSize(t)
+	if size == 0 {
+		return nil, fmt.Errorf("unknown type %d", int32(t))
+	}
+	if !t.isArray() {
+		nelem = 1
+	}
+	if int64(nelem)*int64(size) > int64(len(b)) {
+		return nil, ErrTruncated
+	}
+	o := d.order
+	switch t {
+	case TypeBooleanValue:
+		return o.Uint32(b) != 0, nil
+	case TypeByte, TypeUint8:
+		return b[0], nil
+	case TypeInt8:
+		return int8(b[0]), nil
+	case TypeInt16:
+		return int16(o.Uint16(b)), nil
+	case TypeUint16:
+		return o.Uint16(b), nil
+	case TypeInt32:
+		return int32(o.Uint32(b)), nil
+	case TypeUint32:
+		return o.Uint32(b), nil
+	case TypeInt64, TypeHrtime:
+		return int64(o.Uint64(b)), nil
+	case TypeUint64:
+		return o.Uint64(b), nil
+	case TypeDouble:
+		return math.Float64frombits(o.Uint64(b)), nil
+	case TypeByteArray, TypeUint8Array:
+		return append([]byte(nil), b[:nelem]...), nil
+	case TypeBooleanArray:
+		v := make([]bool, nelem)
+		for i := range v {
+			v[i] = o.Uint32(b[4*i:]) != 0
+		}
+		return v, nil
+	case TypeInt8Array:
+		v := make([]int8, nelem)
+		for i := range v {
+			v[i] = int8(b[i])
+		}
+		return v, nil
+	case TypeInt16Array:
+		v := make([]int16, nelem)
+		for i := range v {
+			v[i] = int16(o.Uint16(b[2*i:]))
+		}
+		return v, nil
+	case TypeUint16Array:
+		v := make([]uint16, nelem)
+		for i := range v {
+			v[i] = o.Uint16(b[2*i:])
+		}
+		return v, nil
+	case TypeInt32Array:
+		v := make([]int32, nelem)
+		for i := range v {
+			v[i] = int32(o.Uint32(b[4*i:]))
+		}
+		return v, nil
+	case TypeUint32Array:
+		v := make([]uint32, nelem)
+		for i := range v {
+			v[i] = o.Uint32(b[4*i:])
+		}
+		return v, nil
+	case TypeInt64Array:
+		v := make([]int64, nelem)
+		for i := range v {
+			v[i] = int64(o.Uint64(b[8*i:]))
+		}
+		return v, nil
+	case TypeUint64Array:
+		v := make([]uint64, nelem)
+		for i := range v {
+			v[i] = o.Uint64(b[8*i:])
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown type %d", int32(t))
+}
+
+func (e *nativeEncoder) list(l *List, depth int) error {
+	if depth > maxDepth {
+		return ErrTooDeep
+	}
+	e.uint32(uint32(l.Version))
+	e.uint32(l.Flags)
+	for _, p := range l.Pairs {
+		if err := e.pair(p, depth); err != nil {
+			return err
+		}
+	}
+	e.uint32(0)
+	return nil
+}
+
+func (e *nativeEncoder) pair(p Pair, depth int) error {
+	if len(p.Name)+1 > math.MaxInt16 {
+		return fmt.Errorf("nvlist: pair name too long: %d bytes", len(p.Name))
+	}
+	size, err := nativePairSize(p)
+	if err != nil {
+		return err
+	}
+	if size > math.MaxInt32 {
+		return fmt.Errorf("nvlist: pair %q too large: %d bytes", p.Name, size)
+	}
+	start := len(e.buf)
+	e.uint32(uint32(size))
+	e.uint16(uint16(len(p.Name) + 1))
+	e.uint16(0)
+	e.uint32(uint32(p.nelem()))
+	e.uint32(uint32(p.Type))
+	e.buf = append(e.buf, p.Name...)
+	e.buf = append(e.buf, 0)
+	e.pad(start)
+	if err := e.value(p); err != nil {
+		return err
+	}
+	e.pad(start)
+
+	switch v := p.Value.(type) {
+	case *List:
+		return e.list(v, depth+1)
+	case []*List:
+		for _, l := range v {
+			if err := e.list(l, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *nativeEncoder) value(p Pair) error {
+	ok := true
+	switch p.Type {
+	case TypeBoolean:
+	case TypeBooleanValue:
+		var v bool
+		v, ok = p.Value.(bool)
+		e.bool(v)
+	case TypeByte, TypeUint8:
+		var v uint8
+		v, ok = p.Value.(uint8)
+		e.buf = append(e.buf, v)
+	case TypeInt8:
+		var v int8
+		v, ok = p.Value.(int8)
+		e.buf = append(e.buf, byte(v))
+	case TypeInt16:
+		var v int16
+		v, ok = p.Value.(int16)
+		e.uint16(uint16(v))
+	case TypeUint16:
+		var v uint16
+		v, ok = p.Value.(uint16)
+		e.uint16(v)
+	case TypeInt32:
+		var v int32
+		v, ok = p.Value.(int32)
+		e.uint32(uint32(v))
+	case TypeUint32:
+		var v uint32
+		v, ok = p.Value.(uint32)
+		e.uint32(v)
+	case TypeInt64, TypeHrtime:
+		var v int64
+		v, ok = p.Value.(int64)
+		e.uint64(uint64(v))
+	case TypeUint64:
+		var v uint64
+		v, ok = p.Value.(uint64)
+		e.uint64(v)
+	case TypeDouble:
+		var v float64
+		v, ok = p.Value.(float64)
+		e.uint64(math.Float64bits(v))
+	case TypeString:
+		var v string
+		v, ok = p.Value.(string)
+		e.buf = append(e.buf, v...)
+		e.buf = append(e.buf, 0)
+	case TypeByteArray, TypeUint8Array:
+		var v []byte
+		v, ok = p.Value.([]byte)
+		e.buf = append(e.buf, v...)
+	case TypeStringArray:
+		var v []string
+		v, ok = p.Value.([]string)
+		e.zero(len(v) * nativePointerSize)
+		for _, s := range v {
+			e.buf = append(e.buf, s...)
+			e.buf = append(e.buf, 0)
+		}
+	case TypeNvlist:
+		var v *List
+		v, ok = p.Value.(*List)
+		if ok = ok && v != nil; ok {
+			e.nvlist(v)
+		}
+	case TypeNvlistArray:
+		var v []*List
+		v, ok = p.Value.([]*List)
+		if ok = ok && noneNil(v); !ok {
+			break
+		}
+		e.zero(len(v) * nativePointerSize)
+		for _, l := range v {
+			e.nvlist(l)
+		}
+	case TypeBooleanArray:
+		var v []bool
+		v, ok = p.Value.([]bool)
+		for _, b := range v {
+			e.bool(b)
+		}
+	case TypeInt8Array:
+		var v []int8
+		v, ok = p.Value.([]int8)
+		for _, x := range v {
+			e.buf = append(e.buf, byte(x))
+		}
+	case TypeInt16Array:
+		var v []int16
+		v, ok = p.Value.([]int16)
+		for _, x := range v {
+			e.uint16(uint16(x))
+		}
+	case TypeUint16Array:
+		var v []uint16
+		v, ok = p.Value.([]uint16)
+		for _, x := range v {
+			e.uint16(x)
+		}
+	case TypeInt32Array:
+		var v []int32
+		v, ok = p.Value.([]int32)
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeUint32Array:
+		var v []uint32
+		v, ok = p.Value.([]uint32)
+		for _, x := range v {
+			e.uint32(x)
+		}
+	case TypeInt64Array:
+		var v []int64
+		v, ok = p.Value.([]int64)
+		for _, x := range v {
+			e.uint64(uint64(x))
+		}
+	case TypeUint64Array:
+		var v []uint64
+		v, ok = p.Value.([]uint64)
+		for _, x := range v {
+			e.uint64(x)
+		}
+	default:
+		return fmt.Errorf("nvlist: pair %q: unknown type %d", p.Name, int32(p.Type))
+	}
+	if !ok {
+		return p.typeError()
+	}
+	return nil
+}
+
+// nvlist appends the nvlist_t libnvpair embeds in a pair for l, with its
+// private pointer zeroed.
+func (e *nativeEncoder) nvlist(l *List) {
+	e.uint32(uint32(l.Version))
+	e.uint32(l.Flags)
+	e.zero(nativeNvlistSize - 8)
+}
+
+func (e *nativeEncoder) bool(v bool) {
+	if v {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+func (e *nativeEncoder) uint16(v uint16) {
+	var b [2]byte
+	e.order.PutUint16(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *nativeEncoder) uint32(v uint32) {
+	var b [4]byte
+	e.order.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *nativeEncoder) uint64(v uint64) {
+	var b [8]byte
+	e.order.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *nativeEncoder) zero(n int) {
+	e.buf = append(e.buf, make([]byte, n)...)
+}
+
+// pad zero-fills up to the next multiple of 8 bytes after start.
+func (e *nativeEncoder) pad(start int) {
+	e.zero(align8(len(e.buf)-start) - (len(e.buf) - start))
+}
+
+// nativePairSize returns the size of p in native encoding, not counting any
+// nested nvlists.
+func nativePairSize(p Pair) (int, error) {
+	valueSize, err := nativeValueSize(p)
+	if err != nil {
+		return 0, err
+	}
+	return align8(nativePairHeaderSize+len(p.Name)+1) + align8(valueSize), nil
+}
+
+// nativeValueSize returns the size of p's value in native encoding.
+func nativeValueSize(p Pair) (int, error) {
+	switch p.Type {
+	case TypeBoolean:
+		return 0, nil
+	case TypeString:
+		v, ok := p.Value.(string)
+		if !ok {
+			return 0, p.typeError()
+		}
+		return len(v) + 1, nil
+	case TypeStringArray:
+		v, ok := p.Value.([]string)
+		if !ok {
+			return 0, p.typeError()
+		}
+		size := len(v) * nativePointerSize
+		for _, s := range v {
+			size += len(s) + 1
+		}
+		return size, nil
+	case TypeNvlist:
+		return nativeNvlistSize, nil
+	case TypeNvlistArray:
+		return p.nelem() * (nativePointerSize + nativeNvlistSize), nil
+	}
+	size := nativeElemSize(p.Type)
+	if size == 0 {
+		return 0, fmt.Errorf("nvlist: pair %q: unknown type %d", p.Name, int32(p.Type))
+	}
+	if p.Type.isArray() {
+		return p.nelem() * size, nil
+	}
+	return size, nil
+}
+
+// nativeElemSize returns the size in native encoding of a value of the
+// fixed-size type t, or of an element of the array type t.  It's 0 for other
+// types.
+func nativeElemSize(t Type) int {
+	switch t {
+	case TypeByte, TypeInt8, TypeUint8, TypeByteArray, TypeInt8Array, TypeUint8Array:
+		return 1
+	case TypeInt16, TypeUint16, TypeInt16Array, TypeUint16Array:
+		return 2
+	case TypeBooleanValue, TypeInt32, TypeUint32, TypeBooleanArray, TypeInt32Array, TypeUint32Array:
+		return 4
+	case TypeInt64, TypeUint64, TypeHrtime, TypeDouble, TypeInt64Array, TypeUint64Array:
+		return 8
+	}
+	return 0
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
@@ -0,0 +1,271 @@
+// Package nvlist packs and unpacks ZFS name-value lists without libzfs.
+//
+// nvlists are how ZFS passes structured data around: pool configs, vdev and
+// scan stats, events, and the zpool.cache file are all packed nvlists.
+// Unpack and Pack convert between packed nvlists and List, Marshal and
+// Unmarshal between List and Go structs and maps.
+package nvlist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Type is the data type of a Pair's value, numbered as data_type_t in
+// sys/nvpair.h.
+type Type int32
+
+const (
+	TypeUnknown      Type = iota
+	TypeBoolean           // present or absent, Value is always true
+	TypeByte              // uint8
+	TypeInt16             // int16
+	TypeUint16            // uint16
+	TypeInt32             // int32
+	TypeUint32            // uint32
+	TypeInt64             // int64
+	TypeUint64            // uint64
+	TypeString            // string
+	TypeByteArray         // []byte
+	TypeInt16Array        // []int16
+	TypeUint16Array       // []uint16
+	TypeInt32Array        // []int32
+	TypeUint32Array       // []uint32
+	TypeInt64Array        // []int64
+	TypeUint64Array       // []uint64
+	TypeStringArray       // []string
+	TypeHrtime            // int64, nanoseconds
+	TypeNvlist            // *List
+	TypeNvlistArray       // []*List
+	TypeBooleanValue      // bool
+	TypeInt8              // int8
+	TypeUint8             // uint8
+	TypeBooleanArray      // []bool
+	TypeInt8Array         // []int8
+	TypeUint8Array        // []uint8
+	TypeDouble            // float64
+)
+
+// Encoding is a way of packing an nvlist.
+type Encoding byte
+
+const (
+	// Native is the packing host's in-memory layout, as used between
+	// libzfs and the kernel.
+	Native Encoding = 0
+	// XDR is the portable big-endian layout ZFS uses on disk.
+	XDR Encoding = 1
+)
+
+// Packed nvlists start with a 4 byte header: the encoding, the byte order of
+// the host that packed it (only meaningful for native encoding), and two
+// reserved bytes.
+const (
+	headerSize = 4
+
+	bigEndian    = 0
+	littleEndian = 1
+)
+
+// maxDepth bounds how deeply nvlists may nest, so that corrupt input can't
+// exhaust the stack.
+const maxDepth = 64
+
+var (
+	ErrUnsupportedEncoding = errors.New("nvlist: unsupported encoding")
+	ErrBadByteOrder        = errors.New("nvlist: bad byte order")
+	ErrTruncated           = errors.New("nvlist: truncated input")
+	ErrTooDeep             = errors.New("nvlist: nested too deeply")
+)
+
+type (
+	// List is a decoded nvlist.  Pairs are kept in the order they were
+	// packed in.
+	List struct {
+		Version int32
+		Flags   uint32
+		Pairs   []Pair
+	}
+
+	// Pair is a single name-value pair.  The Go type of Value is given by
+	// the comment on its Type.
+	Pair struct {
+		Name  string
+		Type  Type
+		Value interface{}
+	}
+)
+
+// Unpack decodes a packed nvlist, e.g. the contents of zpool.cache, in
+// either encoding.
+func Unpack(data []byte) (*List, error) {
+	if len(data) < headerSize {
+		return nil, ErrTruncated
+	}
+	switch Encoding(data[0]) {
+	case XDR:
+		d := &xdrDecoder{buf: data[headerSize:]}
+		return d.list(0)
+	case Native:
+		var order binary.ByteOrder
+		switch data[1] {
+		case bigEndian:
+			order = binary.BigEndian
+		case littleEndian:
+			order = binary.LittleEndian
+		default:
+			return nil, ErrBadByteOrder
+		}
+		d := &nativeDecoder{buf: data[headerSize:], order: order}
+		return d.list(0)
+	}
+	return nil, ErrUnsupportedEncoding
+}
+
+// Pack encodes l with the given encoding.  Native encoding is always
+// little-endian, which is what the hosts ZFS runs on mostly are.
+func Pack(l *List, enc Encoding) ([]byte, error) {
+	header := []byte{byte(enc), littleEndian, 0, 0}
+	switch enc {
+	case XDR:
+		e := &xdrEncoder{buf: header}
+		if err := e.list(l, 0); err != nil {
+			return nil, err
+		}
+		return e.buf, nil
+	case Native:
+		e := &nativeEncoder{buf: header, order: binary.LittleEndian}
+		if err := e.list(l, 0); err != nil {
+			return nil, err
+		}
+		return e.buf, nil
+	}
+	return nil, ErrUnsupportedEncoding
+}
+
+// Lookup returns the first pair called name.
+func (l *List) Lookup(name string) (Pair, bool) {
+	for _, p := range l.Pairs {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Pair{}, false
+}
+
+// Uint64 returns the value of the uint64 pair called name.
+func (l *List) Uint64(name string) (uint64, bool) {
+	p, _ := l.Lookup(name)
+	v, ok := p.Value.(uint64)
+	return v, ok
+}
+
+// String returns the value of the string pair called name.
+func (l *List) String(name string) (string, bool) {
+	p, _ := l.Lookup(name)
+	v, ok := p.Value.(string)
+	return v, ok
+}
+
+// Nvlist returns the value of the nvlist pair called name.
+func (l *List) Nvlist(name string) (*List, bool) {
+	p, _ := l.Lookup(name)
+	v, ok := p.Value.(*List)
+	return v, ok
+}
+
+// Map returns the pairs of l as a map from name to value, with nested nvlists
+// converted to maps as well.  Where names repeat, the last pair wins.
+func (l *List) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(l.Pairs))
+	for _, p := range l.Pairs {
+		switch v := p.Value.(type) {
+		case *List:
+			m[p.Name] = v.Map()
+		case []*List:
+			maps := make([]map[string]interface{}, len(v))
+			for i, nested := range v {
+				maps[i] = nested.Map()
+			}
+			m[p.Name] = maps
+		default:
+			m[p.Name] = v
+		}
+	}
+	return m
+}
+
+// nelem returns the element count packed along with a pair's value.
+func (p Pair) nelem() int {
+	switch v := p.Value.(type) {
+	case []byte:
+		return len(v)
+	case []int8:
+		return len(v)
+	case []int16:
+		return len(v)
+	case []uint16:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []uint32:
+		return len(v)
+	case []int64:
+		return len(v)
+	case []uint64:
+		return len(v)
+	case []bool:
+		return len(v)
+	case []string:
+		return len(v)
+	case []*List:
+		return len(v)
+	}
+	if p.Type == TypeBoolean {
+		return 0
+	}
+	return 1
+}
+
+// typeError reports that p's Value doesn't have the Go type its Type calls
+// for, or is a nil nvlist.
+func (p Pair) typeError() error {
+	return fmt.Errorf("nvlist: pair %q: %v value of Go type %T", p.Name, p.Type, p.Value)
+}
+
+// noneNil reports whether every list in lists is non-nil, as those of a
+// TypeNvlistArray pair must be.
+func noneNil(lists []*List) bool {
+	for _, l := range lists {
+		if l == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Type) isArray() bool {
+	switch t {
+	case TypeByteArray, TypeInt16Array, TypeUint16Array, TypeInt32Array,
+		TypeUint32Array, TypeInt64Array, TypeUint64Array, TypeStringArray,
+		TypeNvlistArray, TypeBooleanArray, TypeInt8Array, TypeUint8Array:
+		return true
+	}
+	return false
+}
+
+func (t Type) String() string {
+	if t >= 0 && int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+	return fmt.Sprintf("Type(%d)", int32(t))
+}
+
+var typeNames = []string{
+	"unknown", "boolean", "byte", "int16", "uint16", "int32", "uint32",
+	"int64", "uint64", "string", "byte array", "int16 array", "uint16 array",
+	"int32 array", "uint32 array", "int64 array", "uint64 array",
+	"string array", "hrtime", "nvlist", "nvlist array", "boolean value",
+	"int8", "uint8", "boolean array", "int8 array", "uint8 array", "double",
+}
@@ -0,0 +1,216 @@
+package nvlist
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// nvUniqueName is NV_UNIQUE_NAME, the flag ZFS packs its nvlists with.
+const nvUniqueName = 1
+
+// everyType returns an nvlist with a pair of every Type, nested nvlists and
+// empty arrays included.
+func everyType() *List {
+	leaf := func(name string) *List {
+		return &List{Flags: nvUniqueName, Pairs: []Pair{{Name: "name", Type: TypeString, Value: name}}}
+	}
+	return &List{Flags: nvUniqueName, Pairs: []Pair{
+		{Name: "boolean", Type: TypeBoolean, Value: true},
+		{Name: "byte", Type: TypeByte, Value: uint8(0xfe)},
+		{Name: "int16", Type: TypeInt16, Value: int16(math.MinInt16)},
+		{Name: "uint16", Type: TypeUint16, Value: uint16(math.MaxUint16)},
+		{Name: "int32", Type: TypeInt32, Value: int32(math.MinInt32)},
+		{Name: "uint32", Type: TypeUint32, Value: uint32(math.MaxUint32)},
+		{Name: "int64", Type: TypeInt64, Value: int64(math.MinInt64)},
+		{Name: "uint64", Type: TypeUint64, Value: uint64(math.MaxUint64)},
+		{Name: "string", Type: TypeString, Value: "tank/home"},
+		{Name: "empty string", Type: TypeString, Value: ""},
+		{Name: "byte array", Type: TypeByteArray, Value: []byte{1, 2, 3, 4, 5}},
+		{Name: "int16 array", Type: TypeInt16Array, Value: []int16{-1, 0, 1}},
+		{Name: "uint16 array", Type: TypeUint16Array, Value: []uint16{0, math.MaxUint16}},
+		{Name: "int32 array", Type: TypeInt32Array, Value: []int32{math.MinInt32, math.MaxInt32, 7}},
+		{Name: "uint32 array", Type: TypeUint32Array, Value: []uint32{1}},
+		{Name: "int64 array", Type: TypeInt64Array, Value: []int64{math.MinInt64, 0}},
+		{Name: "uint64 array", Type: TypeUint64Array, Value: []uint64{math.MaxUint64, 1, 2}},
+		{Name: "empty uint64 array", Type: TypeUint64Array, Value: []uint64{}},
+		{Name: "string array", Type: TypeStringArray, Value: []string{"a", "", "bcdefghij"}},
+		{Name: "hrtime", Type: TypeHrtime, Value: int64(1500000000123456789)},
+		{Name: "nvlist", Type: TypeNvlist, Value: &List{Flags: nvUniqueName, Pairs: []Pair{
+			{Name: "nested", Type: TypeNvlist, Value: leaf("deep")},
+		}}},
+		{Name: "empty nvlist", Type: TypeNvlist, Value: &List{Flags: nvUniqueName}},
+		{Name: "nvlist array", Type: TypeNvlistArray, Value: []*List{leaf("sda"), leaf("sdb")}},
+		{Name: "empty nvlist array", Type: TypeNvlistArray, Value: []*List{}},
+		{Name: "boolean value", Type: TypeBooleanValue, Value: true},
+		{Name: "int8", Type: TypeInt8, Value: int8(math.MinInt8)},
+		{Name: "uint8", Type: TypeUint8, Value: uint8(math.MaxUint8)},
+		{Name: "boolean array", Type: TypeBooleanArray, Value: []bool{true, false, true}},
+		{Name: "int8 array", Type: TypeInt8Array, Value: []int8{-128, 127}},
+		{Name: "uint8 array", Type: TypeUint8Array, Value: []byte{0, 255}},
+		{Name: "double", Type: TypeDouble, Value: 1.5},
+	}}
+}
+
+// TestRoundTrip packs a pair of every Type in both encodings, and checks that
+// unpacking gives back the same nvlist, which packs to the same bytes.
+func TestRoundTrip(t *testing.T) {
+	in := everyType()
+	covered := map[Type]bool{}
+	for _, p := range in.Pairs {
+		covered[p.Type] = true
+	}
+	for typ := TypeBoolean; typ <= TypeDouble; typ++ {
+		if !covered[typ] {
+			t.Errorf("no %v pair", typ)
+		}
+	}
+
+	for _, enc := range []Encoding{XDR, Native} {
+		data, err := Pack(in, enc)
+		if err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		out, err := Unpack(data)
+		if err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		if len(out.Pairs) != len(in.Pairs) {
+			t.Fatalf("%v: got %d pairs, want %d", enc, len(out.Pairs), len(in.Pairs))
+		}
+		for i, want := range in.Pairs {
+			if got := out.Pairs[i]; !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: got %#v, want %#v", enc, got, want)
+			}
+		}
+		repacked, err := Pack(out, enc)
+		if err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		if !reflect.DeepEqual(repacked, data) {
+			t.Errorf("%v: repacking changes the bytes", enc)
+		}
+
+		// Truncated or corrupted input must be rejected, not crash.
+		for i := range data {
+			Unpack(data[:i])
+			corrupt := append([]byte(nil), data...)
+			corrupt[i] ^= 0xff
+			Unpack(corrupt)
+		}
+	}
+}
+
+// TestPackBadValues checks that pairs whose Value doesn't fit their Type,
+// nil nvlists included, are rejected by both encodings.
+func TestPackBadValues(t *testing.T) {
+	for _, p := range []Pair{
+		{Name: "nil nvlist", Type: TypeNvlist, Value: (*List)(nil)},
+		{Name: "nil in nvlist array", Type: TypeNvlistArray, Value: []*List{{}, nil}},
+		{Name: "untyped nil", Type: TypeNvlist},
+		{Name: "wrong type", Type: TypeUint64, Value: "1"},
+		{Name: "unknown type", Type: Type(99), Value: uint64(1)},
+	} {
+		for _, enc := range []Encoding{XDR, Native} {
+			if _, err := Pack(&List{Pairs: []Pair{p}}, enc); err == nil {
+				t.Errorf("%v: packed %s", enc, p.Name)
+			}
+		}
+	}
+}
+
+// TestMarshalRoundTrip checks that a struct survives Marshal, Pack, Unpack and
+// Unmarshal in both encodings.
+func TestMarshalRoundTrip(t *testing.T) {
+	type vdev struct {
+		GUID uint64 `nvlist:"guid"`
+		Path string `nvlist:"path"`
+	}
+	type config struct {
+		Name     string  `nvlist:"name"`
+		State    uint64  `nvlist:"state"`
+		Txg      int32   `nvlist:"txg"`
+		Children []vdev  `nvlist:"children"`
+		Tree     *vdev   `nvlist:"vdev_tree"`
+		Ratio    float64 `nvlist:"ratio"`
+		Paths    []string
+		Missing  *vdev
+	}
+	in := config{
+		Name:     "tank",
+		State:    3,
+		Txg:      -5,
+		Children: []vdev{{1, "/dev/sda1"}, {2, "/dev/sdb1"}},
+		Tree:     &vdev{9, "/dev/sdc1"},
+		Ratio:    1.5,
+		Paths:    []string{"a", "b"},
+	}
+	l, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enc := range []Encoding{XDR, Native} {
+		data, err := Pack(l, enc)
+		if err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		unpacked, err := Unpack(data)
+		if err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		var out config
+		if err := Unmarshal(unpacked, &out); err != nil {
+			t.Fatalf("%v: %v", enc, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("%v: got %+v, want %+v", enc, out, in)
+		}
+	}
+}
+
+// TestUnmarshalBadValues checks that pairs with nil values, which Unpack
+// never produces, are rejected by Unmarshal rather than crashing it.
+func TestUnmarshalBadValues(t *testing.T) {
+	type config struct {
+		Name     string   `nvlist:"name"`
+		Tree     *config  `nvlist:"vdev_tree"`
+		Children []config `nvlist:"children"`
+	}
+	for _, p := range []Pair{
+		{Name: "name", Type: TypeString},
+		{Name: "vdev_tree", Type: TypeNvlist, Value: (*List)(nil)},
+		{Name: "children", Type: TypeNvlistArray, Value: []*List{{}, nil}},
+	} {
+		var out config
+		if err := Unmarshal(&List{Pairs: []Pair{p}}, &out); err == nil {
+			t.Errorf("unmarshaled %s with value %#v", p.Name, p.Value)
+		}
+	}
+	var out config
+	if err := Unmarshal(nil, &out); err == nil {
+		t.Error("unmarshaled nil nvlist")
+	}
+}
+
+// FuzzUnpack checks that Unpack doesn't crash or hang on any input, and that
+// whatever it accepts can be packed again.
+func FuzzUnpack(f *testing.F) {
+	for _, enc := range []Encoding{XDR, Native} {
+		data, err := Pack(everyType(), enc)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		l, err := Unpack(data)
+		if err != nil {
+			return
+		}
+		for _, enc := range []Encoding{XDR, Native} {
+			if _, err := Pack(l, enc); err != nil {
+				t.Errorf("%v: can't pack what was unpacked: %v", enc, err)
+			}
+		}
+	})
+}
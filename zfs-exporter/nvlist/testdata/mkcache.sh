@@ -0,0 +1,29 @@
+#!/bin/sh
+# mkcache.sh makes the zpool.cache fixtures TestUnpackCachefile reads, from
+# real pools backed by files, so that Unpack is checked against what ZFS
+# writes rather than against Pack.  Run it as root on a host with ZFS on
+# Linux and the libnvpair headers (e.g. libzfslinux-dev) installed.  It
+# creates, then destroys, pools zfsexp_tank and zfsexp_backup.
+#
+# mirror-raidz.xdr.cache is the cache file as ZFS writes it, in XDR.
+# mirror-raidz.native.cache is the same nvlist repacked by libnvpair in
+# native encoding.
+set -e
+cd "$(dirname "$0")"
+
+dir=/var/tmp/zfs-exporter-fixtures
+mkdir "$dir"
+trap 'zpool destroy zfsexp_tank 2>/dev/null; zpool destroy zfsexp_backup 2>/dev/null; rm -rf "$dir"' EXIT
+for f in a b c d e f g h; do
+	truncate -s 128M "$dir/$f"
+done
+
+# -f because mirror and raidz1 top-level vdevs don't match.
+zpool create -f -o cachefile="$dir/zpool.cache" zfsexp_tank \
+	mirror "$dir/a" "$dir/b" raidz "$dir/c" "$dir/d" "$dir/e" \
+	spare "$dir/f" cache "$dir/g"
+zpool create -o cachefile="$dir/zpool.cache" zfsexp_backup "$dir/h"
+
+cc -o "$dir/repack" repack.c -I/usr/include/libspl -I/usr/include/libzfs -lnvpair
+cp "$dir/zpool.cache" mirror-raidz.xdr.cache
+"$dir/repack" <mirror-raidz.xdr.cache >mirror-raidz.native.cache
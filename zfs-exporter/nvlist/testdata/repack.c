@@ -0,0 +1,30 @@
+/*
+ * repack reads a packed nvlist on stdin and writes it to stdout in native
+ * encoding.  It's used by mkcache.sh.
+ */
+#include <stdio.h>
+#include <stdlib.h>
+#include <libnvpair.h>
+
+int
+main(void)
+{
+	static char in[1 << 20];
+	size_t len, outlen = 0;
+	char *out = NULL;
+	nvlist_t *nvl;
+
+	len = fread(in, 1, sizeof (in), stdin);
+	if (len == 0 || len == sizeof (in) ||
+	    nvlist_unpack(in, len, &nvl, 0) != 0) {
+		fprintf(stderr, "repack: unable to unpack stdin\n");
+		return (1);
+	}
+	if (nvlist_pack(nvl, &out, &outlen, NV_ENCODE_NATIVE, 0) != 0) {
+		fprintf(stderr, "repack: unable to pack\n");
+		return (1);
+	}
+	if (fwrite(out, 1, outlen, stdout) != outlen)
+		return (1);
+	return (0);
+}
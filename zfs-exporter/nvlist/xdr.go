@@ -0,0 +1,504 @@
+package nvlist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// xdrDecoder decodes the XDR encoding of nvlists, which is what ZFS writes
+// to disk, and xdrEncoder encodes it.  Every item takes a multiple of 4
+// bytes, big-endian:
+//
+//	list: version int32, flags uint32, pair..., 0 int32, 0 int32
+//	pair: encoded size int32, decoded size int32, name string, type int32,
+//	      nelem int32, value
+//
+// The encoded size covers the whole pair, nested nvlists included.  Values
+// narrower than 4 bytes are widened to 4, arrays other than byte and string
+// arrays are prefixed with their length, and nested nvlists follow the pair
+// header inline.  The decoded size is what the pair takes up in native
+// encoding, which libnvpair allocates before decoding it.
+type xdrDecoder struct {
+	buf []byte
+	off int
+}
+
+type xdrEncoder struct {
+	buf []byte
+}
+
+func (d *xdrDecoder) list(depth int) (*List, error) {
+	if depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	version, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	l := &List{Version: int32(version), Flags: flags}
+
+	for {
+		start := d.off
+		encSize, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := d.uint32(); err != nil {
+			return nil, err
+		}
+		if encSize == 0 {
+			return l, nil
+		}
+		if uint64(encSize) > uint64(len(d.buf)-start) {
+			return nil, ErrTruncated
+		}
+		end := start + int(encSize)
+
+		p, err := d.pair(depth)
+		if err != nil {
+			return nil, err
+		}
+		if d.off > end {
+			return nil, fmt.Errorf("nvlist: pair %q overruns its size", p.Name)
+		}
+		d.off = end
+		l.Pairs = append(l.Pairs, p)
+	}
+}
+
+func (d *xdrDecoder) pair(depth int) (Pair, error) {
+	var p Pair
+	var err error
+	if p.Name, err = d.string(); err != nil {
+		return p, err
+	}
+	typ, err := d.uint32()
+	if err != nil {
+		return p, err
+	}
+	p.Type = Type(typ)
+	nelem, err := d.uint32()
+	if err != nil {
+		return p, err
+	}
+	p.Value, err = d.value(p.Type, int(nelem), depth)
+	if err != nil {
+		return p, fmt.Errorf("nvlist: pair %q: %v", p.Name, err)
+	}
+	return p, nil
+}
+
+func (d *xdrDecoder) value(t Type, nelem int, depth int) (interface{}, error) {
+	switch t {
+	case TypeBoolean:
+		return true, nil
+	case TypeBooleanValue:
+		v, err := d.uint32()
+		return v != 0, err
+	case TypeByte, TypeUint8:
+		v, err := d.uint32()
+		return uint8(v), err
+	case TypeInt8:
+		v, err := d.uint32()
+		return int8(v), err
+	case TypeInt16:
+		v, err := d.uint32()
+		return int16(v), err
+	case TypeUint16:
+		v, err := d.uint32()
+		return uint16(v), err
+	case TypeInt32:
+		v, err := d.uint32()
+		return int32(v), err
+	case TypeUint32:
+		return d.uint32()
+	case TypeInt64, TypeHrtime:
+		v, err := d.uint64()
+		return int64(v), err
+	case TypeUint64:
+		return d.uint64()
+	case TypeDouble:
+		v, err := d.uint64()
+		return math.Float64frombits(v), err
+	case TypeString:
+		return d.string()
+	case TypeByteArray:
+		b, err := d.opaque(nelem)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case TypeStringArray:
+		if err := d.check(nelem, 4); err != nil {
+			return nil, err
+		}
+		v := make([]string, nelem)
+		for i := range v {
+			s, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			v[i] = s
+		}
+		return v, nil
+	case TypeNvlist:
+		return d.list(depth + 1)
+	case TypeNvlistArray:
+		if err := d.check(nelem, 16); err != nil {
+			return nil, err
+		}
+		v := make([]*List, nelem)
+		for i := range v {
+			l, err := d.list(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = l
+		}
+		return v, nil
+	}
+
+	if t < TypeByte || t > TypeDouble {
+		return nil, fmt.Errorf("unknown type %d", int32(t))
+	}
+	// Everything else is a length-prefixed array.
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) != nelem {
+		return nil, fmt.Errorf("%v length %d, expected %d", t, n, nelem)
+	}
+	// Elements take at least 4 bytes; check before allocating for them.
+	if err := d.check(nelem, 4); err != nil {
+		return nil, err
+	}
+	switch t {
+	case TypeBooleanArray:
+		v := make([]bool, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, d.rawUint32() != 0) })
+		return v, err
+	case TypeInt8Array:
+		v := make([]int8, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, int8(d.rawUint32())) })
+		return v, err
+	case TypeUint8Array:
+		v := make([]uint8, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, uint8(d.rawUint32())) })
+		return v, err
+	case TypeInt16Array:
+		v := make([]int16, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, int16(d.rawUint32())) })
+		return v, err
+	case TypeUint16Array:
+		v := make([]uint16, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, uint16(d.rawUint32())) })
+		return v, err
+	case TypeInt32Array:
+		v := make([]int32, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, int32(d.rawUint32())) })
+		return v, err
+	case TypeUint32Array:
+		v := make([]uint32, 0, nelem)
+		err := d.each(nelem, 4, func() { v = append(v, d.rawUint32()) })
+		return v, err
+	case TypeInt64Array:
+		v := make([]int64, 0, nelem)
+		err := d.each(nelem, 8, func() { v = append(v, int64(d.rawUint64())) })
+		return v, err
+	case TypeUint64Array:
+		v := make([]uint64, 0, nelem)
+		err := d.each(nelem, 8, func() { v = append(v, d.rawUint64()) })
+		return v, err
+	}
+	return nil, fmt.Errorf("unknown type %d", int32(t))
+}
+
+// check returns an error unless there's room for nelem items of at least
+// size bytes each.  It's called before allocating for nelem items, so that a
+// corrupt count can't make us allocate more than the input could hold.
+func (d *xdrDecoder) check(nelem, size int) error {
+	if nelem < 0 || nelem > (len(d.buf)-d.off)/size {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// each calls read nelem times, having checked there's room for it to read
+// size bytes each time.
+func (d *xdrDecoder) each(nelem, size int, read func()) error {
+	if err := d.check(nelem, size); err != nil {
+		return err
+	}
+	for i := 0; i < nelem; i++ {
+		read()
+	}
+	return nil
+}
+
+func (d *xdrDecoder) uint32() (uint32, error) {
+	if len(d.buf)-d.off < 4 {
+		return 0, ErrTruncated
+	}
+	return d.rawUint32(), nil
+}
+
+func (d *xdrDecoder) uint64() (uint64, error) {
+	if len(d.buf)-d.off < 8 {
+		return 0, ErrTruncated
+	}
+	return d.rawUint64(), nil
+}
+
+func (d *xdrDecoder) rawUint32() uint32 {
+	v := binary.BigEndian.Uint32(d.buf[d.off:])
+	d.off += 4
+	return v
+}
+
+func (d *xdrDecoder) rawUint64() uint64 {
+	v := binary.BigEndian.Uint64(d.buf[d.off:])
+	d.off += 8
+	return v
+}
+
+// opaque returns the next n bytes, skipping the padding after them.
+func (d *xdrDecoder) opaque(n int) ([]byte, error) {
+	padded := (n + 3) &^ 3
+	if n < 0 || padded < n || padded > len(d.buf)-d.off {
+		return nil, ErrTruncated
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += padded
+	return b, nil
+}
+
+func (d *xdrDecoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	if uint64(n) > uint64(len(d.buf)-d.off) {
+		return "", ErrTruncated
+	}
+	b, err := d.opaque(int(n))
+	return string(b), err
+}
+
+func (e *xdrEncoder) list(l *List, depth int) error {
+	if depth > maxDepth {
+		return ErrTooDeep
+	}
+	e.uint32(uint32(l.Version))
+	e.uint32(l.Flags)
+	for _, p := range l.Pairs {
+		if err := e.pair(p, depth); err != nil {
+			return err
+		}
+	}
+	e.uint32(0)
+	e.uint32(0)
+	return nil
+}
+
+func (e *xdrEncoder) pair(p Pair, depth int) error {
+	decSize, err := nativePairSize(p)
+	if err != nil {
+		return err
+	}
+	start := len(e.buf)
+	e.uint32(0) // encoded size, filled in below
+	e.uint32(uint32(decSize))
+	e.string(p.Name)
+	e.uint32(uint32(p.Type))
+	e.uint32(uint32(p.nelem()))
+	if err := e.value(p, depth); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(e.buf[start:], uint32(len(e.buf)-start))
+	return nil
+}
+
+func (e *xdrEncoder) value(p Pair, depth int) error {
+	ok := true
+	switch p.Type {
+	case TypeBoolean:
+	case TypeBooleanValue:
+		var v bool
+		if v, ok = p.Value.(bool); v {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	case TypeByte, TypeUint8:
+		var v uint8
+		v, ok = p.Value.(uint8)
+		e.uint32(uint32(v))
+	case TypeInt8:
+		var v int8
+		v, ok = p.Value.(int8)
+		e.uint32(uint32(v))
+	case TypeInt16:
+		var v int16
+		v, ok = p.Value.(int16)
+		e.uint32(uint32(v))
+	case TypeUint16:
+		var v uint16
+		v, ok = p.Value.(uint16)
+		e.uint32(uint32(v))
+	case TypeInt32:
+		var v int32
+		v, ok = p.Value.(int32)
+		e.uint32(uint32(v))
+	case TypeUint32:
+		var v uint32
+		v, ok = p.Value.(uint32)
+		e.uint32(v)
+	case TypeInt64, TypeHrtime:
+		var v int64
+		v, ok = p.Value.(int64)
+		e.uint64(uint64(v))
+	case TypeUint64:
+		var v uint64
+		v, ok = p.Value.(uint64)
+		e.uint64(v)
+	case TypeDouble:
+		var v float64
+		v, ok = p.Value.(float64)
+		e.uint64(math.Float64bits(v))
+	case TypeString:
+		var v string
+		v, ok = p.Value.(string)
+		e.string(v)
+	case TypeByteArray:
+		var v []byte
+		v, ok = p.Value.([]byte)
+		e.opaque(v)
+	case TypeStringArray:
+		var v []string
+		v, ok = p.Value.([]string)
+		for _, s := range v {
+			e.string(s)
+		}
+	case TypeNvlist:
+		var v *List
+		v, ok = p.Value.(*List)
+		if ok = ok && v != nil; ok {
+			return e.list(v, depth+1)
+		}
+	case TypeNvlistArray:
+		var v []*List
+		v, ok = p.Value.([]*List)
+		if ok = ok && noneNil(v); !ok {
+			break
+		}
+		for _, l := range v {
+			if err := e.list(l, depth+1); err != nil {
+				return err
+			}
+		}
+	case TypeBooleanArray:
+		var v []bool
+		v, ok = p.Value.([]bool)
+		e.uint32(uint32(len(v)))
+		for _, b := range v {
+			if b {
+				e.uint32(1)
+			} else {
+				e.uint32(0)
+			}
+		}
+	case TypeInt8Array:
+		var v []int8
+		v, ok = p.Value.([]int8)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeUint8Array:
+		var v []uint8
+		v, ok = p.Value.([]uint8)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeInt16Array:
+		var v []int16
+		v, ok = p.Value.([]int16)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeUint16Array:
+		var v []uint16
+		v, ok = p.Value.([]uint16)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeInt32Array:
+		var v []int32
+		v, ok = p.Value.([]int32)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(uint32(x))
+		}
+	case TypeUint32Array:
+		var v []uint32
+		v, ok = p.Value.([]uint32)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint32(x)
+		}
+	case TypeInt64Array:
+		var v []int64
+		v, ok = p.Value.([]int64)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint64(uint64(x))
+		}
+	case TypeUint64Array:
+		var v []uint64
+		v, ok = p.Value.([]uint64)
+		e.uint32(uint32(len(v)))
+		for _, x := range v {
+			e.uint64(x)
+		}
+	default:
+		return fmt.Errorf("nvlist: pair %q: unknown type %d", p.Name, int32(p.Type))
+	}
+	if !ok {
+		return p.typeError()
+	}
+	return nil
+}
+
+func (e *xdrEncoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *xdrEncoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// opaque appends b padded to a multiple of 4 bytes.
+func (e *xdrEncoder) opaque(b []byte) {
+	e.buf = append(e.buf, b...)
+	for len(e.buf)%4 != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *xdrEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.opaque([]byte(s))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// poolStateNames and poolStatusNames are indexed by zfs.PoolState and
+	// zfs.PoolStatus.  They come from the linked libzfs, as its numbering
+	// of statuses differs from release to release.
+	poolStateNames  = zfs.PoolStateNames()
+	poolStatusNames = zfs.PoolStatusNames()
+
+	poolstateDesc = prometheus.NewDesc(
+		"zfs_zpool_poolstate",
+		"pool state enum: Active, Exported, Destroyed, Spare, L2cache, uninitialized, unavail, potentiallyactive.  Deprecated, use zfs_zpool_state.",
+		[]string{"poolname"},
+		nil)
+
+	poolstatusDesc = prometheus.NewDesc(
+		"zfs_zpool_poolstatus",
+		"pool status enum, numbered as by the libzfs the exporter is built against, which varies between releases.  Deprecated, use zfs_zpool_status.",
+		[]string{"poolname"},
+		nil)
+
+	poolstatesetDesc = prometheus.NewDesc(
+		"zfs_zpool_state",
+		"1 for the state the pool is in, 0 for the others.",
+		[]string{"poolname", "state"},
+		nil)
+
+	poolstatussetDesc = prometheus.NewDesc(
+		"zfs_zpool_status",
+		"1 for the status libzfs reports for the pool (the first problem found, or ok), 0 for the others.",
+		[]string{"poolname", "status"},
+		nil)
+
+	poolhealthDesc = prometheus.NewDesc(
+		"zfs_zpool_health_info",
+		"always 1; the health label is the pool's health property, e.g. ONLINE or DEGRADED.",
+		[]string{"poolname", "health"},
+		nil)
+)
+
+// poolCollector reports the state, status and health of the pool as a whole.
+type poolCollector struct{}
+
+func init() {
+	registerCollector("pool", true, func() subCollector { return poolCollector{} })
+}
+
+// Describe implements subCollector.
+func (poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolstateDesc
+	ch <- poolstatusDesc
+	ch <- poolstatesetDesc
+	ch <- poolstatussetDesc
+	ch <- poolhealthDesc
+}
+
+// Update implements subCollector.
+func (poolCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	pstate, err := ps.pool.State()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(poolstateDesc,
+			prometheus.GaugeValue,
+			-1,
+			ps.poolName)
+		return fmt.Errorf("error getting state: %v", err)
+	}
+	ch <- prometheus.MustNewConstMetric(poolstateDesc,
+		prometheus.GaugeValue,
+		float64(pstate),
+		ps.poolName)
+	collectStateSet(ch, poolstatesetDesc, poolStateNames, uint64(pstate), ps.poolName)
+
+	pstatus, err := ps.pool.Status()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(poolstatusDesc,
+			prometheus.GaugeValue,
+			-1,
+			ps.poolName)
+		return fmt.Errorf("error getting status: %v", err)
+	}
+	ch <- prometheus.MustNewConstMetric(poolstatusDesc,
+		prometheus.GaugeValue,
+		float64(pstatus),
+		ps.poolName)
+	collectStateSet(ch, poolstatussetDesc, poolStatusNames, uint64(pstatus), ps.poolName)
+
+	health, err := ps.pool.GetProperty(zfs.PoolPropHealth)
+	if err != nil {
+		return fmt.Errorf("error getting health: %v", err)
+	}
+	ch <- prometheus.MustNewConstMetric(poolhealthDesc,
+		prometheus.GaugeValue,
+		1,
+		ps.poolName, health.Value)
+	return nil
+}
+
+// collectStateSet sends one series per entry in names, with value 1 for
+// names[v] and 0 for the rest.  desc's last label must be the one holding the
+// name; labelValues are those preceding it.
+func collectStateSet(ch chan<- prometheus.Metric, desc *prometheus.Desc, names []string, v uint64, labelValues ...string) {
+	for i, name := range names {
+		value := 0.0
+		if uint64(i) == v {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc,
+			prometheus.GaugeValue,
+			value,
+			append(labelValues, name)...)
+	}
+}
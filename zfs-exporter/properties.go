@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// numericPoolProp maps a numeric pool property to the gauge it's exported as.
+type numericPoolProp struct {
+	prop zfs.Prop
+	desc *prometheus.Desc
+}
+
+var (
+	numericPoolProps = []numericPoolProp{
+		newNumericPoolProp(zfs.PoolPropSize, "size_bytes", "total size of the pool."),
+		newNumericPoolProp(zfs.PoolPropAllocated, "allocated_bytes", "amount of storage allocated within the pool."),
+		newNumericPoolProp(zfs.PoolPropFree, "free_bytes", "amount of free space in the pool."),
+		newNumericPoolProp(zfs.PoolPropFreeing, "freeing_bytes", "amount of space still being freed in the background after dataset destruction."),
+		newNumericPoolProp(zfs.PoolPropLeaked, "leaked_bytes", "amount of space leaked by background freeing, which won't be reclaimed."),
+		newNumericPoolProp(zfs.PoolPropExpandsz, "expandsize_bytes", "amount of uninitialized space the pool could grow by."),
+		newNumericPoolProp(zfs.PoolPropCapacity, "capacity_percent", "percentage of pool space used."),
+		newNumericPoolProp(zfs.PoolPropFragmentaion, "fragmentation_percent", "percentage of free space that is fragmented."),
+		newNumericPoolProp(zfs.PoolPropDedupratio, "dedupratio", "deduplication ratio achieved by the pool."),
+		newNumericPoolProp(zfs.PoolPropAshift, "ashift", "base 2 logarithm of the pool sector size, 0 for autodetected."),
+		newNumericPoolProp(zfs.PoolPropMaxBlockSize, "maxblocksize_bytes", "largest block size allowed in the pool."),
+	}
+
+	poolinfoDesc = prometheus.NewDesc(
+		"zfs_zpool_info",
+		"always 1; labels give the pool's string-valued properties.",
+		[]string{"poolname", "health", "autoexpand", "autoreplace", "failmode", "readonly", "altroot", "guid", "version"},
+		nil)
+)
+
+func newNumericPoolProp(prop zfs.Prop, name, help string) numericPoolProp {
+	return numericPoolProp{
+		prop: prop,
+		desc: prometheus.NewDesc(
+			"zfs_zpool_property_"+name,
+			help,
+			[]string{"poolname"},
+			nil),
+	}
+}
+
+// propertiesCollector reports pool properties, reloading them on every
+// refresh.
+type propertiesCollector struct{}
+
+func init() {
+	registerCollector("properties", true, func() subCollector { return propertiesCollector{} })
+}
+
+// Describe implements subCollector.
+func (propertiesCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, np := range numericPoolProps {
+		ch <- np.desc
+	}
+	ch <- poolinfoDesc
+}
+
+// Update implements subCollector.
+func (propertiesCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	if err := ps.ReloadProperties(); err != nil {
+		return fmt.Errorf("unable to reload properties: %v", err)
+	}
+	props := ps.pool.Properties()
+
+	var firstErr error
+	for _, np := range numericPoolProps {
+		v, ok, err := parsePropNumber(props[np.prop].Value)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unable to parse property %s: %v", zfs.PoolPropertyToName(np.prop), err)
+			}
+			continue
+		}
+		if ok {
+			ch <- prometheus.MustNewConstMetric(np.desc, prometheus.GaugeValue, v, ps.poolName)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(poolinfoDesc, prometheus.GaugeValue, 1,
+		ps.poolName,
+		props[zfs.PoolPropHealth].Value,
+		props[zfs.PoolPropAutoexpand].Value,
+		props[zfs.PoolPropAutoreplace].Value,
+		props[zfs.PoolPropFailuremode].Value,
+		props[zfs.PoolPropReadonly].Value,
+		props[zfs.PoolPropAltroot].Value,
+		props[zfs.PoolPropGUID].Value,
+		props[zfs.PoolPropVersion].Value)
+	return firstErr
+}
+
+// parsePropNumber parses a numeric property value.  Properties are read in
+// literal form, as with zpool get -p and zfs get -p, so sizes are exact byte
+// counts and ratios have no x, e.g. "1.00".  ok is false if the property has
+// no value, which libzfs shows as "-" or "none".
+func parsePropNumber(s string) (v float64, ok bool, err error) {
+	if s == "" || s == "-" || s == "none" {
+		return 0, false, nil
+	}
+	v, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return v, true, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	vdevredundancyDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_redundancy_remaining",
+		"number of further device failures the top-level vdev can survive, e.g. 0 for a raidz1 with one faulted disk; -1 if it has already lost data.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	poolredundancyDesc = prometheus.NewDesc(
+		"zfs_zpool_redundancy_remaining",
+		"number of further device failures the pool is guaranteed to survive, the minimum over its top-level data vdevs; -1 if it has already lost data.",
+		[]string{"poolname"},
+		nil)
+)
+
+// redundancyCollector reports how many more device failures each top-level
+// vdev, and the pool as a whole, can tolerate.
+type redundancyCollector struct{}
+
+func init() {
+	registerCollector("redundancy", true, func() subCollector { return redundancyCollector{} })
+}
+
+// Describe implements subCollector.
+func (redundancyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vdevredundancyDesc
+	ch <- poolredundancyDesc
+}
+
+// Update implements subCollector.
+func (redundancyCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	vdt, err := ps.VDevTree()
+	if err != nil {
+		return fmt.Errorf("unable to read vdevtree: %v", err)
+	}
+
+	resilvering := vdt.ScanStat.Func == zfs.PoolScanResilver && vdt.ScanStat.State == zfs.DSSScanning
+	poolRemaining, haveData := 0, false
+	emit := func(top zfs.VDevTree, role string) int {
+		remaining := redundancyRemaining(top, resilvering)
+		ch <- prometheus.MustNewConstMetric(vdevredundancyDesc, prometheus.GaugeValue,
+			float64(remaining), ps.poolName, string(top.Type), top.Name, fmt.Sprintf("%d", top.Id), role)
+		return remaining
+	}
+	for _, top := range vdt.Devices {
+		if top.Type == zfs.VDevTypeHole || top.Type == zfs.VDevTypeMissing {
+			continue
+		}
+		role := "data"
+		if top.AllocBias != "" {
+			role = top.AllocBias
+		}
+		remaining := emit(top, role)
+		if !haveData || remaining < poolRemaining {
+			poolRemaining, haveData = remaining, true
+		}
+	}
+	// Losing a log device costs at most the last few seconds of synchronous
+	// writes, so logs don't count towards the pool's redundancy.
+	for _, top := range vdt.Logs {
+		emit(top, "log")
+	}
+
+	if haveData {
+		ch <- prometheus.MustNewConstMetric(poolredundancyDesc, prometheus.GaugeValue,
+			float64(poolRemaining), ps.poolName)
+	}
+	return nil
+}
+
+// redundancyRemaining returns the number of device failures vdt can absorb
+// before it stops working, or -1 if it has stopped working already.  A leaf
+// is lost once it's below VDevStateDegraded, e.g. offline or faulted, or,
+// while resilvering, if the resilver has written to it: like zpool status
+// does, take that to mean it doesn't have all its data yet.  A vdev with
+// children is lost once more than its tolerance of children are lost: parity
+// for raidz, all but one for mirror, replacing and spare vdevs.  The failures
+// it can absorb are those needed to take out the cheapest tolerance+1
+// surviving children, less one.
+func redundancyRemaining(vdt zfs.VDevTree, resilvering bool) int {
+	if len(vdt.Devices) == 0 {
+		if vdt.Stat.State < zfs.VDevStateDegraded {
+			return -1
+		}
+		if resilvering && vdt.Stat.ScanProcessed != 0 {
+			return -1
+		}
+		return 0
+	}
+
+	var tolerance int
+	switch vdt.Type {
+	case zfs.VDevTypeMirror, zfs.VDevTypeReplacing, zfs.VDevTypeSpare:
+		tolerance = len(vdt.Devices) - 1
+	default:
+		tolerance = int(vdt.Parity)
+	}
+
+	var costs []int
+	for _, child := range vdt.Devices {
+		if r := redundancyRemaining(child, resilvering); r >= 0 {
+			costs = append(costs, r+1)
+		} else {
+			tolerance--
+		}
+	}
+	if tolerance < 0 {
+		return -1
+	}
+	if tolerance >= len(costs) {
+		tolerance = len(costs) - 1
+	}
+
+	sort.Ints(costs)
+	failures := 0
+	for _, cost := range costs[:tolerance+1] {
+		failures += cost
+	}
+	return failures - 1
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ncabatoff/go-libzfs"
+)
+
+// TestRedundancyRemaining checks the failures various vdev trees can absorb,
+// with and without a resilver running.
+func TestRedundancyRemaining(t *testing.T) {
+	ok := func(name string) zfs.VDevTree {
+		return zfs.VDevTree{Type: zfs.VDevTypeDisk, Name: name, Stat: zfs.VDevStat{State: zfs.VDevStateHealthy}}
+	}
+	faulted := func(name string) zfs.VDevTree {
+		d := ok(name)
+		d.Stat.State = zfs.VDevStateFaulted
+		return d
+	}
+	// resilvered is a disk the resilver has written to, which only holds
+	// all its data once the resilver is done.
+	resilvered := func(name string) zfs.VDevTree {
+		d := ok(name)
+		d.Stat.ScanProcessed = 1 << 20
+		return d
+	}
+	vdev := func(typ zfs.VDevType, parity uint, devices ...zfs.VDevTree) zfs.VDevTree {
+		return zfs.VDevTree{Type: typ, Parity: parity, Devices: devices, Stat: zfs.VDevStat{State: zfs.VDevStateHealthy}}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		vdt         zfs.VDevTree
+		resilvering bool
+		want        int
+	}{
+		{"healthy disk", ok("sda"), false, 0},
+		{"faulted disk", faulted("sda"), false, -1},
+		{"2-way mirror", vdev(zfs.VDevTypeMirror, 0, ok("sda"), ok("sdb")), false, 1},
+		{"3-way mirror, one faulted", vdev(zfs.VDevTypeMirror, 0, ok("sda"), faulted("sdb"), ok("sdc")), false, 1},
+		{"raidz1", vdev(zfs.VDevTypeRaidz, 1, ok("sda"), ok("sdb"), ok("sdc")), false, 1},
+		{"raidz1, one faulted", vdev(zfs.VDevTypeRaidz, 1, ok("sda"), faulted("sdb"), ok("sdc")), false, 0},
+		{"raidz1, two faulted", vdev(zfs.VDevTypeRaidz, 1, faulted("sda"), faulted("sdb"), ok("sdc")), false, -1},
+		{"raidz2, one faulted", vdev(zfs.VDevTypeRaidz, 2, ok("sda"), faulted("sdb"), ok("sdc"), ok("sdd")), false, 1},
+		{
+			name: "mirror, replacing a faulted disk, resilver done",
+			vdt: vdev(zfs.VDevTypeMirror, 0, ok("sda"),
+				vdev(zfs.VDevTypeReplacing, 0, faulted("sdb"), resilvered("sdc"))),
+			want: 1,
+		},
+		{
+			name: "mirror, replacing a faulted disk, resilvering",
+			vdt: vdev(zfs.VDevTypeMirror, 0, ok("sda"),
+				vdev(zfs.VDevTypeReplacing, 0, faulted("sdb"), resilvered("sdc"))),
+			resilvering: true,
+			want:        0,
+		},
+		{
+			name: "raidz1, spare resilvering in for a faulted disk",
+			vdt: vdev(zfs.VDevTypeRaidz, 1, ok("sda"), ok("sdb"),
+				vdev(zfs.VDevTypeSpare, 0, faulted("sdc"), resilvered("sdd"))),
+			resilvering: true,
+			want:        0,
+		},
+		{
+			name: "raidz1, disk resilvering back after being offline",
+			vdt:  vdev(zfs.VDevTypeRaidz, 1, ok("sda"), ok("sdb"), resilvered("sdc")),
+			// The disks the resilver reads from aren't written to,
+			// and so still count.
+			resilvering: true,
+			want:        0,
+		},
+		{
+			// Losing sdb, then both sides of the mirror, takes three
+			// failures.
+			name: "raidz2 with a mirror under replacing",
+			vdt: vdev(zfs.VDevTypeRaidz, 2, faulted("sda"), ok("sdb"),
+				vdev(zfs.VDevTypeReplacing, 0, faulted("sdc"),
+					vdev(zfs.VDevTypeMirror, 0, ok("sdd"), ok("sde")))),
+			want: 2,
+		},
+		{
+			name: "raidz2 with a mirror under replacing, resilvering",
+			vdt: vdev(zfs.VDevTypeRaidz, 2, faulted("sda"), ok("sdb"),
+				vdev(zfs.VDevTypeReplacing, 0, faulted("sdc"),
+					vdev(zfs.VDevTypeMirror, 0, ok("sdd"), resilvered("sde")))),
+			resilvering: true,
+			want:        1,
+		},
+	} {
+		if got := redundancyRemaining(tc.vdt, tc.resilvering); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
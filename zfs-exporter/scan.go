@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scanFuncNames = []string{
+		"none",
+		"scrub",
+		"resilver",
+	}
+
+	scanStateNames = []string{
+		"none",
+		"scanning",
+		"finished",
+		"canceled",
+	}
+
+	scaninfoDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_info",
+		"always 1; labels give the function (none, scrub, resilver) and state (none, scanning, finished, canceled) of the pool's most recent scan.",
+		[]string{"poolname", "function", "state"},
+		nil)
+
+	scanstartDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_start_timestamp_seconds",
+		"unix time at which the most recent scan started.",
+		[]string{"poolname"},
+		nil)
+
+	scanendDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_end_timestamp_seconds",
+		"unix time at which the most recent scan ended, 0 if it's still running.",
+		[]string{"poolname"},
+		nil)
+
+	scantoexamineDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_to_examine_bytes",
+		"number of bytes the scan has to examine in total.",
+		[]string{"poolname"},
+		nil)
+
+	scanexaminedDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_examined_bytes",
+		"number of bytes examined so far by the scan.",
+		[]string{"poolname"},
+		nil)
+
+	scanprocessedDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_processed_bytes",
+		"number of bytes repaired or resilvered so far by the scan.",
+		[]string{"poolname"},
+		nil)
+
+	scanerrorsDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_errors",
+		"number of errors encountered by the scan.",
+		[]string{"poolname"},
+		nil)
+
+	scanprogressDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_progress_ratio",
+		"fraction of the bytes to examine that the scan has examined.",
+		[]string{"poolname"},
+		nil)
+
+	scanrateDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_rate_bytes_per_second",
+		"bytes examined per second during the current pass of a running scan.",
+		[]string{"poolname"},
+		nil)
+
+	scanetaDesc = prometheus.NewDesc(
+		"zfs_zpool_scan_estimated_completion_timestamp_seconds",
+		"unix time at which a running scan will finish if it keeps its current rate.",
+		[]string{"poolname"},
+		nil)
+
+	lastscrubDesc = prometheus.NewDesc(
+		"zfs_zpool_last_scrub_completed_timestamp_seconds",
+		"unix time at which the last scrub to run to completion finished.",
+		[]string{"poolname"},
+		nil)
+
+	lastscruberrorsDesc = prometheus.NewDesc(
+		"zfs_zpool_last_scrub_errors",
+		"number of errors found by the last scrub to run to completion.",
+		[]string{"poolname"},
+		nil)
+)
+
+type (
+	// scanCollector reports the progress of the pool's scrub or resilver.
+	//
+	// ZFS only keeps the stats of the most recent scan, so a resilver or a
+	// canceled scrub hides when the pool was last scrubbed.  scanCollector
+	// therefore remembers the last completed scrub it saw for each pool.
+	// Until it has seen one since the exporter started, the last scrub
+	// metrics are omitted.  Scrubs are remembered by pool guid, so that a
+	// pool recreated or imported under the name of another doesn't inherit
+	// its scrubs, and forgotten when the pool goes away.
+	scanCollector struct {
+		mu         sync.Mutex
+		lastScrubs map[uint64]completedScrub
+		// guids maps the name of each pool in lastScrubs to its guid.
+		guids map[string]uint64
+	}
+
+	completedScrub struct {
+		endTime uint64
+		errors  uint64
+	}
+)
+
+func init() {
+	registerCollector("scan", true, func() subCollector {
+		return &scanCollector{
+			lastScrubs: make(map[uint64]completedScrub),
+			guids:      make(map[string]uint64),
+		}
+	})
+}
+
+// Describe implements subCollector.
+func (*scanCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scaninfoDesc
+	ch <- scanstartDesc
+	ch <- scanendDesc
+	ch <- scantoexamineDesc
+	ch <- scanexaminedDesc
+	ch <- scanprocessedDesc
+	ch <- scanerrorsDesc
+	ch <- scanprogressDesc
+	ch <- scanrateDesc
+	ch <- scanetaDesc
+	ch <- lastscrubDesc
+	ch <- lastscruberrorsDesc
+}
+
+// Update implements subCollector.
+func (c *scanCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	vdt, err := ps.VDevTree()
+	if err != nil {
+		return fmt.Errorf("unable to read vdevtree: %v", err)
+	}
+	poolName := ps.poolName
+	ss := vdt.ScanStat
+
+	ch <- prometheus.MustNewConstMetric(scaninfoDesc, prometheus.GaugeValue, 1,
+		poolName, enumName(scanFuncNames, ss.Func), enumName(scanStateNames, ss.State))
+
+	// The root vdev's guid is the pool's.
+	if last, ok := c.lastScrub(poolName, vdt.GUID, ss); ok {
+		ch <- prometheus.MustNewConstMetric(lastscrubDesc, prometheus.GaugeValue,
+			float64(last.endTime), poolName)
+		ch <- prometheus.MustNewConstMetric(lastscruberrorsDesc, prometheus.GaugeValue,
+			float64(last.errors), poolName)
+	}
+
+	if ss.Func == zfs.PoolScanNone {
+		return nil
+	}
+
+	ch <- prometheus.MustNewConstMetric(scanstartDesc, prometheus.GaugeValue,
+		float64(ss.StartTime), poolName)
+	ch <- prometheus.MustNewConstMetric(scanendDesc, prometheus.GaugeValue,
+		float64(ss.EndTime), poolName)
+	ch <- prometheus.MustNewConstMetric(scantoexamineDesc, prometheus.GaugeValue,
+		float64(ss.ToExamine), poolName)
+	ch <- prometheus.MustNewConstMetric(scanexaminedDesc, prometheus.GaugeValue,
+		float64(ss.Examined), poolName)
+	ch <- prometheus.MustNewConstMetric(scanprocessedDesc, prometheus.GaugeValue,
+		float64(ss.Processed), poolName)
+	ch <- prometheus.MustNewConstMetric(scanerrorsDesc, prometheus.GaugeValue,
+		float64(ss.Errors), poolName)
+
+	if ss.ToExamine > 0 {
+		ch <- prometheus.MustNewConstMetric(scanprogressDesc, prometheus.GaugeValue,
+			float64(ss.Examined)/float64(ss.ToExamine), poolName)
+	}
+
+	if ss.State != zfs.DSSScanning {
+		return nil
+	}
+
+	// Same arithmetic as zpool status: the rate is that of the current pass,
+	// which restarts e.g. when the pool is imported.
+	now := time.Now()
+	elapsed := now.Unix() - int64(ss.PassStart)
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+	rate := float64(ss.PassExam) / float64(elapsed)
+	ch <- prometheus.MustNewConstMetric(scanrateDesc, prometheus.GaugeValue,
+		rate, poolName)
+
+	if rate > 0 && ss.ToExamine >= ss.Examined {
+		eta := float64(ss.ToExamine-ss.Examined) / rate
+		ch <- prometheus.MustNewConstMetric(scanetaDesc, prometheus.GaugeValue,
+			float64(now.Unix())+eta, poolName)
+	}
+	return nil
+}
+
+// lastScrub records ss if it describes a finished scrub, and returns the
+// most recent finished scrub seen for the pool with the given guid.
+func (c *scanCollector) lastScrub(poolName string, guid uint64, ss zfs.PoolScanStat) (completedScrub, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.guids[poolName]; ok && old != guid {
+		delete(c.lastScrubs, old)
+	}
+	c.guids[poolName] = guid
+	if ss.Func == zfs.PoolScanScrub && ss.State == zfs.DSSFinished {
+		c.lastScrubs[guid] = completedScrub{endTime: ss.EndTime, errors: ss.Errors}
+	}
+	last, ok := c.lastScrubs[guid]
+	return last, ok
+}
+
+// forgetPool implements poolForgetter.
+func (c *scanCollector) forgetPool(poolName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if guid, ok := c.guids[poolName]; ok {
+		delete(c.lastScrubs, guid)
+		delete(c.guids, poolName)
+	}
+}
+
+// enumName returns names[v], or "unknown" if v is out of range.
+func enumName(names []string, v uint64) string {
+	if v < uint64(len(names)) {
+		return names[v]
+	}
+	return "unknown"
+}
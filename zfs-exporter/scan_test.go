@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncabatoff/go-libzfs"
+)
+
+// TestLastScrubFollowsPoolGUID checks that the last completed scrub is
+// forgotten when its pool goes away, and isn't inherited by another pool
+// that later takes the same name.
+func TestLastScrubFollowsPoolGUID(t *testing.T) {
+	const lastScrub = `zfs_zpool_last_scrub_completed_timestamp_seconds{poolname="tank"} 1.5000036e+09`
+
+	fb := &fakeBackend{pools: []*fakePool{healthyPool()}}
+	z := NewZfsCollector(fb, CollectorOpts{PoolTimeout: time.Second, Collectors: enabledCollectors()})
+	if err := z.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if out := string(scrape(t, z)); !strings.Contains(out, lastScrub) {
+		t.Fatalf("missing %s:\n%s", lastScrub, out)
+	}
+	sc := z.Collectors["scan"].(*scanCollector)
+
+	// Export tank: what was remembered about it goes.
+	fb.pools = nil
+	scrape(t, z)
+	sc.mu.Lock()
+	n := len(sc.lastScrubs) + len(sc.guids)
+	sc.mu.Unlock()
+	if n != 0 {
+		t.Errorf("scan collector still remembers %d entries for a pool that went away", n)
+	}
+
+	// A new pool called tank, e.g. recreated between discoveries, starts
+	// with no last scrub, and the old pool's is dropped.
+	finished := zfs.PoolScanStat{Func: zfs.PoolScanScrub, State: zfs.DSSFinished, EndTime: 1500003600}
+	if _, ok := sc.lastScrub("tank", 10, finished); !ok {
+		t.Fatal("finished scrub not remembered")
+	}
+	resilver := zfs.PoolScanStat{Func: zfs.PoolScanResilver, State: zfs.DSSScanning}
+	if last, ok := sc.lastScrub("tank", 11, resilver); ok {
+		t.Errorf("recreated pool inherited its predecessor's last scrub %+v", last)
+	}
+	if _, ok := sc.lastScrubs[10]; ok {
+		t.Error("last scrub of the replaced pool not forgotten")
+	}
+}
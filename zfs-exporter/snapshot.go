@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// snapshotPrefixes are the name prefixes snapshots are grouped by.  It's
+	// set from -zfs.snapshot-prefixes before the collectors are created.
+	snapshotPrefixes []string
+
+	snapshotcountDesc = prometheus.NewDesc(
+		"zfs_dataset_snapshots",
+		"number of snapshots of the dataset whose name starts with prefix; prefix is empty for those matching no configured prefix.",
+		[]string{"poolname", "dataset", "prefix"},
+		nil)
+
+	snapshotnewestDesc = prometheus.NewDesc(
+		"zfs_dataset_snapshot_newest_creation_timestamp_seconds",
+		"unix time at which the dataset's most recent snapshot with the prefix was taken.",
+		[]string{"poolname", "dataset", "prefix"},
+		nil)
+
+	snapshotoldestDesc = prometheus.NewDesc(
+		"zfs_dataset_snapshot_oldest_creation_timestamp_seconds",
+		"unix time at which the dataset's oldest snapshot with the prefix was taken.",
+		[]string{"poolname", "dataset", "prefix"},
+		nil)
+
+	snapshotusedDesc = prometheus.NewDesc(
+		"zfs_dataset_snapshot_used_bytes",
+		"sum of the space used by each of the dataset's snapshots with the prefix, i.e. what destroying them one at a time would free, not counting blocks shared between snapshots.",
+		[]string{"poolname", "dataset", "prefix"},
+		nil)
+)
+
+// snapshotCollector reports how many snapshots each filesystem and volume
+// has, how old they are and how much space they hold, grouped by prefix.
+// Like the dataset collector it's off by default, as it opens every snapshot
+// on each refresh.
+type snapshotCollector struct {
+	prefixes []string
+}
+
+// snapshotGroup sums up the snapshots of a dataset sharing a prefix.
+type snapshotGroup struct {
+	count          int
+	newest, oldest float64
+	used           float64
+}
+
+func init() {
+	registerCollector("snapshot", false, func() subCollector {
+		return snapshotCollector{prefixes: snapshotPrefixes}
+	})
+}
+
+// Describe implements subCollector.
+func (snapshotCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotcountDesc
+	ch <- snapshotnewestDesc
+	ch <- snapshotoldestDesc
+	ch <- snapshotusedDesc
+}
+
+// Update implements subCollector.
+func (c snapshotCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	root, err := ps.Datasets()
+	if err != nil {
+		return fmt.Errorf("unable to open datasets: %v", err)
+	}
+
+	var firstErr error
+	visitDatasets(root, func(d DatasetHandle) {
+		if d.Type() != zfs.DatasetTypeFilesystem && d.Type() != zfs.DatasetTypeVolume {
+			return
+		}
+		name := d.Properties()[zfs.DatasetPropName].Value
+
+		groups := make(map[string]*snapshotGroup, len(c.prefixes)+1)
+		groups[""] = &snapshotGroup{}
+		for _, prefix := range c.prefixes {
+			groups[prefix] = &snapshotGroup{}
+		}
+		for _, snap := range d.Children() {
+			if snap.Type() != zfs.DatasetTypeSnapshot {
+				continue
+			}
+			if err := c.addSnapshot(groups, snap.Properties()); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("unable to read snapshot of %s: %v", name, err)
+			}
+		}
+
+		for prefix, g := range groups {
+			ch <- prometheus.MustNewConstMetric(snapshotcountDesc, prometheus.GaugeValue,
+				float64(g.count), ps.poolName, name, prefix)
+			if g.count == 0 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(snapshotnewestDesc, prometheus.GaugeValue,
+				g.newest, ps.poolName, name, prefix)
+			ch <- prometheus.MustNewConstMetric(snapshotoldestDesc, prometheus.GaugeValue,
+				g.oldest, ps.poolName, name, prefix)
+			ch <- prometheus.MustNewConstMetric(snapshotusedDesc, prometheus.GaugeValue,
+				g.used, ps.poolName, name, prefix)
+		}
+	})
+	return firstErr
+}
+
+// addSnapshot adds the snapshot with properties props to the group of the
+// first prefix its name matches.
+func (c snapshotCollector) addSnapshot(groups map[string]*snapshotGroup, props map[zfs.Prop]zfs.Property) error {
+	snapName := props[zfs.DatasetPropName].Value
+	if i := strings.IndexByte(snapName, '@'); i >= 0 {
+		snapName = snapName[i+1:]
+	}
+	g := groups[""]
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(snapName, prefix) {
+			g = groups[prefix]
+			break
+		}
+	}
+
+	creation, _, err := parsePropNumber(props[zfs.DatasetPropCreation].Value)
+	if err != nil {
+		return err
+	}
+	used, _, err := parsePropNumber(props[zfs.DatasetPropUsed].Value)
+	if err != nil {
+		return err
+	}
+
+	if g.count == 0 || creation > g.newest {
+		g.newest = creation
+	}
+	if g.count == 0 || creation < g.oldest {
+		g.oldest = creation
+	}
+	g.used += used
+	g.count++
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ncabatoff/go-libzfs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	zioTypeNames = []string{
+		"Null",
+		"Read",
+		"Write",
+		"Free",
+		"Claim",
+		"IoCtl",
+	}
+
+	// vdevAuxNames are indexed by zfs.VDevAux.
+	vdevAuxNames = []string{
+		"none",
+		"open_failed",
+		"corrupt_data",
+		"no_replicas",
+		"bad_guid_sum",
+		"too_small",
+		"bad_label",
+		"version_newer",
+		"version_older",
+		"unsup_feat",
+		"spared",
+		"err_exceeded",
+		"io_failure",
+		"bad_log",
+		"external",
+		"split_pool",
+	}
+
+	vdevopsDesc = prometheus.NewDesc(
+		"zfs_zpool_vdevops_total",
+		"number of operations performed.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role", "vdevoptype"},
+		nil)
+
+	vdevbytesDesc = prometheus.NewDesc(
+		"zfs_zpool_vdevbytes_total",
+		"number of bytes handled",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role", "vdevoptype"},
+		nil)
+
+	vdeverrorsDesc = prometheus.NewDesc(
+		"zfs_zpool_errors_total",
+		"number of errors seen",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role", "errortype"},
+		nil)
+
+	vdevstateDesc = prometheus.NewDesc(
+		"zfs_zpool_vdevstate",
+		"vdev state: Unknown, Closed, Offline, Removed, CantOpen, Faulted, Degraded, Healthy.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevauxDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_aux_info",
+		"always 1; the reason label says why the vdev is in its state, e.g. open_failed or corrupt_data for CantOpen, none if there's nothing to say.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role", "reason"},
+		nil)
+
+	vdevdeviceDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_device_info",
+		"always 1; maps a vdev to its guid and, for leaf vdevs, device path, devid and physical path, which survive renames and reimports.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role", "guid", "path", "devid", "phys_path"},
+		nil)
+
+	vdevinfoDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_info",
+		"always 1; places the vdev in the pool's vdev tree. depth is 0 for the root, top_level_index is the id of the top-level vdev it belongs to, empty for the root, spares and cache devices.",
+		[]string{"poolname", "guid", "parent_guid", "type", "parity", "depth", "top_level_index"},
+		nil)
+
+	vdevallocDesc = prometheus.NewDesc(
+		"zfs_zpool_allocated_bytes",
+		"number of bytes allocated (usage)",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevspaceDesc = prometheus.NewDesc(
+		"zfs_zpool_space_bytes",
+		"size of the vdev in bytes (total capacity).",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevfragDesc = prometheus.NewDesc(
+		"zfs_zpool_fragmentation_percent",
+		"device fragmentation percentage",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevdspaceDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_deflated_space_bytes",
+		"deflated capacity of the vdev in bytes, i.e. usable space after parity overhead.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevrsizeDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_replaceable_size_bytes",
+		"size a replacement device needs to have to stand in for this one.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevesizeDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_expandable_size_bytes",
+		"bytes by which the vdev would grow if expanded, e.g. with autoexpand after swapping in bigger disks.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevselfhealedDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_self_healed_bytes_total",
+		"number of bytes of bad data repaired from redundant copies.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevscanprocessedDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_scan_processed_bytes",
+		"number of bytes repaired or resilvered on this vdev by the current scan.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevremovingDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_removing",
+		"1 if the vdev is being removed from the pool.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+
+	vdevloadageDesc = prometheus.NewDesc(
+		"zfs_zpool_vdev_time_since_load_seconds",
+		"seconds since the vdev was loaded, e.g. by pool import.",
+		[]string{"poolname", "vdevtype", "vdevname", "vdevid", "role"},
+		nil)
+)
+
+// vdevCollector reports the state, space and I/O statistics of every vdev.
+type vdevCollector struct{}
+
+func init() {
+	registerCollector("vdev", true, func() subCollector { return vdevCollector{} })
+}
+
+// Describe implements subCollector.
+func (vdevCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- vdevopsDesc
+	ch <- vdevbytesDesc
+	ch <- vdeverrorsDesc
+	ch <- vdevstateDesc
+	ch <- vdevauxDesc
+	ch <- vdevdeviceDesc
+	ch <- vdevinfoDesc
+	ch <- vdevallocDesc
+	ch <- vdevspaceDesc
+	ch <- vdevfragDesc
+	ch <- vdevdspaceDesc
+	ch <- vdevrsizeDesc
+	ch <- vdevesizeDesc
+	ch <- vdevselfhealedDesc
+	ch <- vdevscanprocessedDesc
+	ch <- vdevremovingDesc
+	ch <- vdevloadageDesc
+}
+
+// Update implements subCollector.
+func (vdevCollector) Update(ps *poolScrape, ch chan<- prometheus.Metric) error {
+	vdt, err := ps.VDevTree()
+	if err != nil {
+		return fmt.Errorf("unable to read vdevtree: %v", err)
+	}
+	poolName := ps.poolName
+
+	visitVdevs(ps.pool, vdt, func(pool PoolHandle, vdt zfs.VDevTree, role string) {
+		vType := string(vdt.Type)
+		// log.Printf("visiting pool %s vdev %s id %d type %s path %s", pool.Name(), vdt.Name, vdt.Id, vType, vdt.Path)
+
+		id := fmt.Sprintf("%d", vdt.Id)
+		ch <- prometheus.MustNewConstMetric(vdevstateDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.State), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevauxDesc, prometheus.GaugeValue,
+			1, poolName, vType, vdt.Name, id, role, enumName(vdevAuxNames, uint64(vdt.Stat.Aux)))
+		ch <- prometheus.MustNewConstMetric(vdevdeviceDesc, prometheus.GaugeValue,
+			1, poolName, vType, vdt.Name, id, role,
+			fmt.Sprintf("%d", vdt.GUID), vdt.Path, vdt.DevID, vdt.PhysPath)
+		ch <- prometheus.MustNewConstMetric(vdevallocDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.Alloc), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevspaceDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.Space), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevfragDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.Fragmentation), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevdspaceDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.DSpace), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevrsizeDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.RSize), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevesizeDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.ESize), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevscanprocessedDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.ScanProcessed), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevremovingDesc, prometheus.GaugeValue,
+			float64(vdt.Stat.ScanRemoving), poolName, vType, vdt.Name, id, role)
+		ch <- prometheus.MustNewConstMetric(vdevloadageDesc, prometheus.GaugeValue,
+			vdt.Stat.Timestamp.Seconds(), poolName, vType, vdt.Name, id, role)
+
+		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
+			float64(vdt.Stat.ReadErrors), poolName, vType, vdt.Name, id, role, "read")
+		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
+			float64(vdt.Stat.WriteErrors), poolName, vType, vdt.Name, id, role, "write")
+		ch <- prometheus.MustNewConstMetric(vdeverrorsDesc, prometheus.CounterValue,
+			float64(vdt.Stat.ChecksumErrors), poolName, vType, vdt.Name, id, role, "checksum")
+		ch <- prometheus.MustNewConstMetric(vdevselfhealedDesc, prometheus.CounterValue,
+			float64(vdt.Stat.SelfHealed), poolName, vType, vdt.Name, id, role)
+
+		for optype := zfs.ZIOTypeRead; optype < zfs.ZIOTypes; optype++ {
+			ch <- prometheus.MustNewConstMetric(vdevopsDesc, prometheus.CounterValue,
+				float64(vdt.Stat.Ops[optype]),
+				poolName, vType, vdt.Name, id, role, zioTypeNames[optype])
+		}
+
+		for optype := zfs.ZIOTypeRead; optype < zfs.ZIOTypes; optype++ {
+			ch <- prometheus.MustNewConstMetric(vdevbytesDesc, prometheus.CounterValue,
+				float64(vdt.Stat.Bytes[optype]),
+				poolName, vType, vdt.Name, id, role, zioTypeNames[optype])
+		}
+	})
+
+	visitVdevTopology(vdt, func(vdt zfs.VDevTree, parent uint64, depth, topLevel int) {
+		parentGUID, topLevelIndex := "", ""
+		if depth > 0 {
+			parentGUID = fmt.Sprintf("%d", parent)
+		}
+		if topLevel >= 0 {
+			topLevelIndex = fmt.Sprintf("%d", topLevel)
+		}
+		ch <- prometheus.MustNewConstMetric(vdevinfoDesc, prometheus.GaugeValue,
+			1, poolName, fmt.Sprintf("%d", vdt.GUID), parentGUID, string(vdt.Type),
+			fmt.Sprintf("%d", vdt.Parity), fmt.Sprintf("%d", depth), topLevelIndex)
+	})
+	return nil
+}
+
+// visitVdevs calls visitor on every vdev in the pool whose root is vdt,
+// including the log, spare and cache devices, along with the role the vdev
+// plays in the pool: data, log, spare, cache, special or dedup.
+func visitVdevs(pool PoolHandle, vdt zfs.VDevTree, visitor func(pool PoolHandle, vdt zfs.VDevTree, role string)) {
+	visitVdevTree(pool, vdt, "data", visitor)
+	for _, log := range vdt.Logs {
+		visitVdevTree(pool, log, "log", visitor)
+	}
+	for _, spare := range vdt.Spares {
+		visitVdevTree(pool, spare, "spare", visitor)
+	}
+	for _, cache := range vdt.L2Cache {
+		visitVdevTree(pool, cache, "cache", visitor)
+	}
+}
+
+// visitVdevTree calls visitor on vdt and its descendants.  Children inherit
+// role unless they carry an allocation class of their own.
+func visitVdevTree(pool PoolHandle, vdt zfs.VDevTree, role string, visitor func(pool PoolHandle, vdt zfs.VDevTree, role string)) {
+	visitor(pool, vdt, role)
+	for _, child := range vdt.Devices {
+		childRole := role
+		if child.AllocBias != "" {
+			childRole = child.AllocBias
+		}
+		visitVdevTree(pool, child, childRole, visitor)
+	}
+}
+
+// visitVdevTopology calls visitor on every vdev in the pool whose root is vdt,
+// along with the guid of its parent, its depth below the root and the id of
+// the top-level vdev it belongs to.  topLevel is -1 for the root and for
+// spare and cache devices, which aren't part of any top-level vdev.  Holes
+// and missing vdevs have no guid and are skipped.
+func visitVdevTopology(vdt zfs.VDevTree, visitor func(vdt zfs.VDevTree, parent uint64, depth, topLevel int)) {
+	visitor(vdt, 0, 0, -1)
+	for _, tops := range [][]zfs.VDevTree{vdt.Devices, vdt.Logs} {
+		for _, top := range tops {
+			visitTopologyTree(top, vdt.GUID, 1, int(top.Id), visitor)
+		}
+	}
+	for _, aux := range [][]zfs.VDevTree{vdt.Spares, vdt.L2Cache} {
+		for _, dev := range aux {
+			visitTopologyTree(dev, vdt.GUID, 1, -1, visitor)
+		}
+	}
+}
+
+func visitTopologyTree(vdt zfs.VDevTree, parent uint64, depth, topLevel int, visitor func(vdt zfs.VDevTree, parent uint64, depth, topLevel int)) {
+	if vdt.Type == zfs.VDevTypeHole || vdt.Type == zfs.VDevTypeMissing {
+		return
+	}
+	visitor(vdt, parent, depth, topLevel)
+	for _, child := range vdt.Devices {
+		visitTopologyTree(child, vdt.GUID, depth+1, topLevel, visitor)
+	}
+}